@@ -1,17 +1,29 @@
 package main
 
 import (
+	"os"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/expvar"
+	log "github.com/projectkeas/sdks-service/logger"
 	"github.com/projectkeas/sdks-service/server"
 
 	"github.com/projectkeas/ingestion/handlers/authenticationHandler"
 	"github.com/projectkeas/ingestion/handlers/ingestionHandler"
+	"github.com/projectkeas/ingestion/services/authentication"
+	"github.com/projectkeas/ingestion/services/deadLetters"
 	"github.com/projectkeas/ingestion/services/eventPublisher"
 	"github.com/projectkeas/ingestion/services/eventTypes"
 	"github.com/projectkeas/ingestion/services/ingestionPolicies"
+	"github.com/projectkeas/ingestion/services/resourceStatus"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "webhook" {
+		runWebhook()
+		return
+	}
+
 	app := server.New("ingestion")
 
 	app.WithEnvironmentVariableConfiguration("KEAS_")
@@ -21,13 +33,49 @@ func main() {
 
 	app.ConfigureHandlers(func(f *fiber.App, server *server.Server) {
 		f.Post("/ingest", authenticationHandler.New(server), ingestionHandler.New(server))
+		f.Post("/ingest/explain", authenticationHandler.New(server), ingestionHandler.NewExplain(server))
+		f.Post("/ingest/batch", authenticationHandler.New(server), ingestionHandler.NewBatch(server))
+		f.Post("/webhooks/:provider", ingestionHandler.NewWebhook(server))
+		f.Post("/admin/deadletters/replay", authenticationHandler.New(server), authenticationHandler.RequireAdmin, ingestionHandler.NewReplay(server))
+		f.Get("/debug/vars", authenticationHandler.New(server), authenticationHandler.RequireAdmin, expvar.New())
+
+		listenMutualTLS(f, server)
 	})
 
 	server := app.Build()
 
-	server.RegisterService(ingestionPolicies.SERVICE_NAME, ingestionPolicies.New())
-	server.RegisterService(eventTypes.SERVICE_NAME, eventTypes.New())
-	server.RegisterService(eventPublisher.SERVICE_NAME, eventPublisher.New(server.GetConfiguration()))
+	server.RegisterService(authentication.SERVICE_NAME, authentication.New(server.GetConfiguration()))
+	server.RegisterService(authentication.JWT_SERVICE_NAME, authentication.NewJwt(server.GetConfiguration()))
+	server.RegisterService(authentication.CERTIFICATE_SERVICE_NAME, authentication.NewClientCertificates(server.GetConfiguration()))
+	server.RegisterService(authentication.WEBHOOK_SERVICE_NAME, authentication.NewWebhookProviders(server.GetConfiguration()))
+
+	status := resourceStatus.New(server.GetConfiguration())
+	server.RegisterService(ingestionPolicies.SERVICE_NAME, ingestionPolicies.New(server.GetConfiguration(), status))
+	server.RegisterService(eventTypes.SERVICE_NAME, eventTypes.New(server.GetConfiguration(), status))
+
+	publisher := eventPublisher.New(server.GetConfiguration())
+	server.RegisterService(eventPublisher.SERVICE_NAME, publisher)
+	server.RegisterService(deadLetters.SERVICE_NAME, deadLetters.New(server.GetConfiguration(), publisher))
 
 	server.Run()
 }
+
+// listenMutualTLS also serves the API on ingestion.mtls.port when a certificate and client CA are configured,
+// clients on this port must present a certificate signed by the CA which is used as their identity
+func listenMutualTLS(f *fiber.App, server *server.Server) {
+	config := server.GetConfiguration()
+	certFile := config.GetStringValueOrDefault("ingestion.mtls.certFile", "")
+	keyFile := config.GetStringValueOrDefault("ingestion.mtls.keyFile", "")
+	clientCAFile := config.GetStringValueOrDefault("ingestion.mtls.clientCAFile", "")
+	if certFile == "" || keyFile == "" || clientCAFile == "" {
+		return
+	}
+
+	port := config.GetStringValueOrDefault("ingestion.mtls.port", "5443")
+	go func() {
+		err := f.ListenMutualTLS(":"+port, certFile, keyFile, clientCAFile)
+		if err != nil {
+			log.Logger.Panic(err.Error())
+		}
+	}()
+}
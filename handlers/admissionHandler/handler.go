@@ -0,0 +1,87 @@
+package admissionHandler
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	types "github.com/projectkeas/crds/pkg/apis/keas.io/v1alpha1"
+	"github.com/projectkeas/ingestion/services/eventTypes"
+	"github.com/projectkeas/ingestion/services/ingestionPolicies"
+	log "github.com/projectkeas/sdks-service/logger"
+	"github.com/projectkeas/sdks-service/server"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// New validates EventTypes and IngestionPolicies sent by the Kubernetes API server in an AdmissionReview
+// so that resources that would fail to compile are never admitted to the cluster
+func New(server *server.Server) func(context *fiber.Ctx) error {
+	return func(context *fiber.Ctx) error {
+		review := admissionv1.AdmissionReview{}
+		err := json.Unmarshal(context.Body(), &review)
+		if err != nil || review.Request == nil {
+			log.Logger.Error("Unable to parse admission review", zap.Error(err))
+			return context.Status(fiber.StatusBadRequest).JSON(map[string]interface{}{
+				"message": "The request is not a valid AdmissionReview",
+				"reason":  "request-body",
+			})
+		}
+
+		err = validate(review.Request)
+
+		response := &admissionv1.AdmissionResponse{
+			UID:     review.Request.UID,
+			Allowed: err == nil,
+		}
+		if err != nil {
+			log.Logger.Info("denied admission", zap.String("kind", review.Request.Kind.Kind), zap.Any("resource", map[string]string{
+				"name":      review.Request.Name,
+				"namespace": review.Request.Namespace,
+			}), zap.Error(err))
+
+			response.Result = &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    fiber.StatusUnprocessableEntity,
+				Reason:  metav1.StatusReasonInvalid,
+				Message: err.Error(),
+			}
+		}
+
+		return context.JSON(admissionv1.AdmissionReview{
+			TypeMeta: review.TypeMeta,
+			Response: response,
+		})
+	}
+}
+
+func validate(request *admissionv1.AdmissionRequest) error {
+	// Deletions are always allowed and there is nothing to validate
+	if request.Operation == admissionv1.Delete {
+		return nil
+	}
+
+	switch request.Kind.Kind {
+	case "EventType":
+		eventType := &types.EventType{}
+		err := json.Unmarshal(request.Object.Raw, eventType)
+		if err != nil {
+			return fmt.Errorf("unable to parse the EventType: %w", err)
+		}
+		return eventTypes.ValidateEventType(eventType)
+	case "IngestionPolicy":
+		ingestionPolicy := &types.IngestionPolicy{}
+		err := json.Unmarshal(request.Object.Raw, ingestionPolicy)
+		if err != nil {
+			return fmt.Errorf("unable to parse the IngestionPolicy: %w", err)
+		}
+		if ingestionPolicy.Namespace == "" {
+			ingestionPolicy.Namespace = request.Namespace
+		}
+		return ingestionPolicies.ValidateIngestionPolicy(ingestionPolicy)
+	default:
+		// Only the keas resources are validated, anything else the webhook is registered for is allowed
+		return nil
+	}
+}
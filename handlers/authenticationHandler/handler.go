@@ -1,34 +1,104 @@
 package authenticationHandler
 
 import (
-	"fmt"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/projectkeas/sdks-service/configuration"
+	"github.com/projectkeas/ingestion/services/authentication"
 	log "github.com/projectkeas/sdks-service/logger"
 	"github.com/projectkeas/sdks-service/server"
+	"go.uber.org/zap"
 )
 
-var token string = ""
+const (
+	apiKeyScheme = "ApiKey "
+	bearerScheme = "Bearer "
 
+	// principalLocal is the fiber local that the authenticated principal is stored in
+	principalLocal = "principal"
+)
+
+// New authenticates the request with a verified client certificate, or either an ApiKey or a JWT bearer token
+// in the Authorization header and attaches the principal to the request, see GetPrincipal
 func New(server *server.Server) func(context *fiber.Ctx) error {
 
-	config := server.GetConfiguration()
-	config.RegisterChangeNotificationHandler(func(newConfig configuration.ConfigurationRoot) {
-		token = newConfig.GetStringValueOrDefault("ingestion.auth.token", "")
-	})
+	service, err := server.GetService(authentication.SERVICE_NAME)
+	if err != nil {
+		panic(err)
+	}
+	keys := (*service).(authentication.ApiKeyStore)
+
+	service, err = server.GetService(authentication.JWT_SERVICE_NAME)
+	if err != nil {
+		panic(err)
+	}
+	tokens := (*service).(authentication.JwtAuthenticator)
+
+	service, err = server.GetService(authentication.CERTIFICATE_SERVICE_NAME)
+	if err != nil {
+		panic(err)
+	}
+	certificates := (*service).(authentication.ClientCertificateAuthenticator)
 
 	return func(context *fiber.Ctx) error {
 
-		if token == "" {
-			log.Logger.Warn("No token has been set for authentication")
-			return context.SendStatus(401)
+		// Client certificates are only verified on the mtls listener, there's no connection state otherwise
+		state := context.Context().TLSConnectionState()
+		if state != nil && len(state.VerifiedChains) > 0 {
+			principal, found := certificates.Authenticate(state.VerifiedChains[0][0])
+			if !found {
+				return context.SendStatus(fiber.StatusUnauthorized)
+			}
+
+			context.Locals(principalLocal, &principal)
+			return context.Next()
 		}
 
-		if context.Get("Authorization") == fmt.Sprintf("ApiKey %s", token) {
+		header := context.Get(fiber.HeaderAuthorization)
+
+		if strings.HasPrefix(header, apiKeyScheme) {
+			principal, found := keys.Authenticate(strings.TrimPrefix(header, apiKeyScheme))
+			if !found {
+				return context.SendStatus(fiber.StatusUnauthorized)
+			}
+
+			context.Locals(principalLocal, &principal)
 			return context.Next()
 		}
 
-		return context.SendStatus(401)
+		if strings.HasPrefix(header, bearerScheme) {
+			principal, err := tokens.Authenticate(strings.TrimPrefix(header, bearerScheme))
+			if err != nil {
+				log.Logger.Info("bearer token rejected", zap.Error(err))
+				return context.SendStatus(fiber.StatusUnauthorized)
+			}
+
+			context.Locals(principalLocal, &principal)
+			return context.Next()
+		}
+
+		return context.SendStatus(fiber.StatusUnauthorized)
+	}
+}
+
+// RequireAdmin only allows principals with the admin flag to continue, it must be used after New
+func RequireAdmin(context *fiber.Ctx) error {
+	principal := GetPrincipal(context)
+	if principal == nil || !principal.Admin {
+		if principal != nil {
+			log.Logger.Warn("principal is not permitted to use an admin endpoint", zap.String("principal", principal.Id), zap.String("path", context.Path()))
+		}
+		return context.SendStatus(fiber.StatusForbidden)
+	}
+
+	return context.Next()
+}
+
+// GetPrincipal returns the principal that authenticated the request, or nil when it isn't authenticated
+func GetPrincipal(context *fiber.Ctx) *authentication.Principal {
+	principal, isPrincipal := context.Locals(principalLocal).(*authentication.Principal)
+	if !isPrincipal {
+		return nil
 	}
+	return principal
 }
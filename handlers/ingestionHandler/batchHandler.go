@@ -0,0 +1,107 @@
+package ingestionHandler
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/projectkeas/ingestion/handlers/authenticationHandler"
+	"github.com/projectkeas/ingestion/services/authentication"
+	"github.com/projectkeas/ingestion/services/contentTypes"
+	"github.com/projectkeas/sdks-service/configuration"
+	log "github.com/projectkeas/sdks-service/logger"
+	"github.com/projectkeas/sdks-service/server"
+	"go.uber.org/zap"
+)
+
+// NewBatch accepts a JSON array of structured cloud events (application/cloudevents-batch+json) and runs
+// every event through the same pipeline as a single event. The response contains a result per event
+// in the same order as the request so that callers can retry only the failures.
+func NewBatch(server *server.Server) func(context *fiber.Ctx) error {
+
+	pipeline := newPipeline(server)
+
+	// maxBatchSize is read by every request whilst the configuration can change it
+	maxBatchSize := int64(1000)
+	config := server.GetConfiguration()
+	config.RegisterChangeNotificationHandler(func(newConfig configuration.ConfigurationRoot) {
+		atomic.StoreInt64(&maxBatchSize, int64(newConfig.GetIntValueOrDefault("ingestion.batch.maxSize", 1000)))
+	})
+
+	return func(context *fiber.Ctx) error {
+		context.Accepts("application/json")
+		errorResult := map[string]interface{}{
+			"message": "An error occurred whilst processing your request",
+		}
+
+		if contentTypes.MediaType(context.Get(fiber.HeaderContentType)) != cloudevents.ApplicationCloudEventsBatchJSON {
+			errorResult["message"] = "The request must have a content type of " + cloudevents.ApplicationCloudEventsBatchJSON
+			errorResult["reason"] = "content-type"
+			return context.Status(fiber.StatusUnsupportedMediaType).JSON(errorResult)
+		}
+
+		// Parse the request body
+		batch := []json.RawMessage{}
+		err := json.Unmarshal(context.Body(), &batch)
+		if err != nil {
+			log.Logger.Error("Unable to parse request body", zap.Error(err))
+			errorResult["reason"] = "request-body"
+			return context.Status(fiber.StatusBadRequest).JSON(errorResult)
+		}
+
+		if int64(len(batch)) > atomic.LoadInt64(&maxBatchSize) {
+			errorResult["message"] = "The batch exceeds the maximum number of events allowed in a single request"
+			errorResult["reason"] = "batch-size"
+			return context.Status(fiber.StatusRequestEntityTooLarge).JSON(errorResult)
+		}
+
+		principal := authenticationHandler.GetPrincipal(context)
+		allAccepted := true
+		results := []map[string]interface{}{}
+		for index, rawEvent := range batch {
+			result, id := ingestBatchEvent(pipeline, rawEvent, principal)
+
+			response := map[string]interface{}{
+				"index":  index,
+				"status": "accepted",
+			}
+			if id != "" {
+				response["id"] = id
+			}
+
+			if result.quarantined {
+				for key, value := range result.toMap() {
+					response[key] = value
+				}
+				response["status"] = "quarantined"
+			} else if !result.accepted() {
+				allAccepted = false
+				for key, value := range result.toMap() {
+					response[key] = value
+				}
+				response["status"] = "rejected"
+			}
+
+			results = append(results, response)
+		}
+
+		status := fiber.StatusAccepted
+		if !allAccepted {
+			status = fiber.StatusMultiStatus
+		}
+
+		return context.Status(status).JSON(map[string]interface{}{
+			"results": results,
+		})
+	}
+}
+
+func ingestBatchEvent(pipeline *ingestionPipeline, rawEvent json.RawMessage, principal *authentication.Principal) (ingestionResult, string) {
+	cloudEvent, errorResult := parseStructuredEvent(rawEvent)
+	if errorResult != nil {
+		return *errorResult, cloudEvent.ID()
+	}
+
+	return pipeline.ingest(cloudEvent, principal), cloudEvent.ID()
+}
@@ -0,0 +1,72 @@
+package ingestionHandler
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/projectkeas/ingestion/handlers/authenticationHandler"
+	"github.com/projectkeas/sdks-service/server"
+)
+
+// NewExplain accepts the same request as New and runs it through validation and every ingestion policy
+// without publishing it. The response explains which EventType matched, the result of each policy
+// and the final verdict.
+func NewExplain(server *server.Server) func(context *fiber.Ctx) error {
+
+	pipeline := newPipeline(server)
+
+	return func(context *fiber.Ctx) error {
+		context.Accepts("application/json")
+
+		cloudEvent, errorResult := parseRequest(context)
+		if errorResult != nil {
+			return context.Status(errorResult.status).JSON(errorResult.toMap())
+		}
+
+		result := pipeline.evaluate(&cloudEvent, authenticationHandler.GetPrincipal(context), true)
+
+		response := map[string]interface{}{
+			"verdict": "accepted",
+			"status":  result.status,
+			"event": map[string]interface{}{
+				"id":              cloudEvent.ID(),
+				"type":            cloudEvent.Type(),
+				"source":          cloudEvent.Source(),
+				"dataschema":      cloudEvent.DataSchema(),
+				"datacontenttype": cloudEvent.DataContentType(),
+			},
+			"eventType": nil,
+		}
+
+		if result.quarantined {
+			response["verdict"] = "quarantined"
+		} else if !result.accepted() {
+			response["verdict"] = "rejected"
+		}
+
+		if !result.accepted() || result.quarantined {
+			for key, value := range result.toMap() {
+				response[key] = value
+			}
+		}
+
+		eventType, found := pipeline.eventValidation.Describe(cloudEvent)
+		if found {
+			response["eventType"] = eventType
+		}
+
+		decision := result.decision
+		if decision.Evaluations != nil {
+			response["policies"] = decision.Evaluations
+		}
+
+		if result.accepted() && !result.quarantined {
+			response["decision"] = map[string]interface{}{
+				"extensions":  decision.Extensions,
+				"subject":     decision.Subject,
+				"retention":   decision.Retention,
+				"transformed": decision.Transformed,
+			}
+		}
+
+		return context.Status(fiber.StatusOK).JSON(response)
+	}
+}
@@ -1,161 +1,182 @@
 package ingestionHandler
 
 import (
+	"encoding/json"
+	"fmt"
 	"strings"
 	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	spec "github.com/cloudevents/sdk-go/v2/binding/spec"
-	cee "github.com/cloudevents/sdk-go/v2/event"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
-	"github.com/projectkeas/ingestion/services/eventPublisher"
-	"github.com/projectkeas/ingestion/services/eventTypes"
-	"github.com/projectkeas/ingestion/services/ingestionPolicies"
+	"github.com/projectkeas/ingestion/handlers/authenticationHandler"
+	"github.com/projectkeas/ingestion/services/contentTypes"
 	log "github.com/projectkeas/sdks-service/logger"
 	"github.com/projectkeas/sdks-service/server"
-	jsonSchema "github.com/santhosh-tekuri/jsonschema/v5"
 	"go.uber.org/zap"
 )
 
 var Validator = validator.New()
 
-func New(server *server.Server) func(context *fiber.Ctx) error {
-
-	// payload validation setup
-	eventTypesService, err := server.GetService(eventTypes.SERVICE_NAME)
-	if err != nil {
-		panic(err)
-	}
-	eventValidation := (*eventTypesService).(eventTypes.EventTypeService)
+const extensionHeaderPrefix = "ce-"
 
-	// ingestion engine setup
-	ingestionService, err := server.GetService(ingestionPolicies.SERVICE_NAME)
-	if err != nil {
-		panic(err)
-	}
-	ingestionPolicyEngine := (*ingestionService).(ingestionPolicies.IngestionPolicyService)
+// reservedAttributeNames cannot be used as extensions as they clash with the event format
+var reservedAttributeNames = map[string]bool{
+	"data":                true,
+	"datacontentencoding": true,
+	"schemaurl":           true,
+}
 
-	// publisher setup
-	nc, err := server.GetService(eventPublisher.SERVICE_NAME)
-	if err != nil {
-		panic(err)
-	}
-	client := (*nc).(eventPublisher.EventPublisherService)
+// cloudevents setup
+var (
+	specs = spec.New().Version("1.0")
+
+	dataContentTypeHeader = extensionHeaderPrefix + specs.AttributeFromKind(spec.DataContentType).Name()
+	dataSchemaHeader      = extensionHeaderPrefix + specs.AttributeFromKind(spec.DataSchema).Name()
+	idHeader              = extensionHeaderPrefix + specs.AttributeFromKind(spec.ID).Name()
+	sourceHeader          = extensionHeaderPrefix + specs.AttributeFromKind(spec.Source).Name()
+	specVersionHeader     = extensionHeaderPrefix + specs.AttributeFromKind(spec.SpecVersion).Name()
+	subjectHeader         = extensionHeaderPrefix + specs.AttributeFromKind(spec.Subject).Name()
+	typeHeader            = extensionHeaderPrefix + specs.AttributeFromKind(spec.Type).Name()
+	timeHeader            = extensionHeaderPrefix + specs.AttributeFromKind(spec.Time).Name()
+)
 
-	// cloudevents setup
-	var specs = spec.New().Version("1.0")
+func New(server *server.Server) func(context *fiber.Ctx) error {
 
-	dataContentTypeHeader := "ce-" + specs.AttributeFromKind(spec.DataContentType).Name()
-	dataSchemaHeader := "ce-" + specs.AttributeFromKind(spec.DataSchema).Name()
-	idHeader := "ce-" + specs.AttributeFromKind(spec.ID).Name()
-	sourceHeader := "ce-" + specs.AttributeFromKind(spec.Source).Name()
-	specVersionHeader := "ce-" + specs.AttributeFromKind(spec.SpecVersion).Name()
-	subjectHeader := "ce-" + specs.AttributeFromKind(spec.Subject).Name()
-	typeHeader := "ce-" + specs.AttributeFromKind(spec.Type).Name()
-	timeHeader := "ce-" + specs.AttributeFromKind(spec.Time).Name()
+	pipeline := newPipeline(server)
 
 	return func(context *fiber.Ctx) error {
 		context.Accepts("application/json")
-		errorResult := map[string]interface{}{
-			"message": "An error occurred whilst processing your request",
-		}
 
-		// Parse the request body
-		requestBody := map[string]interface{}{}
-		err := context.BodyParser(&requestBody)
-		if err != nil {
-			log.Logger.Error("Unable to parse request body", zap.Error(err))
-			errorResult["reason"] = "request-body"
-			return context.Status(fiber.StatusBadRequest).JSON(errorResult)
+		cloudEvent, errorResult := parseRequest(context)
+		if errorResult != nil {
+			return context.Status(errorResult.status).JSON(errorResult.toMap())
 		}
 
-		cloudEvent := cloudevents.NewEvent()
-		cloudEvent.SetData(*cloudevents.StringOfApplicationJSON(), requestBody)
-
-		// Map all the headers to the cloud event
-		for key, value := range context.GetReqHeaders() {
-			if strings.EqualFold(key, dataContentTypeHeader) {
-				cloudEvent.SetDataContentType(value)
-			} else if strings.EqualFold(key, dataSchemaHeader) {
-				cloudEvent.SetDataSchema(value)
-			} else if strings.EqualFold(key, idHeader) {
-				cloudEvent.SetID(value)
-			} else if strings.EqualFold(key, sourceHeader) {
-				cloudEvent.SetSource(value)
-			} else if strings.EqualFold(key, specVersionHeader) {
-				cloudEvent.SetSpecVersion(value)
-			} else if strings.EqualFold(key, subjectHeader) {
-				cloudEvent.SetSubject(value)
-			} else if strings.EqualFold(key, typeHeader) {
-				cloudEvent.SetType(value)
-			} else if strings.EqualFold(key, timeHeader) {
-				t, err := time.Parse(time.RFC3339, value)
-				if err == nil {
-					cloudEvent.SetTime(t.UTC())
-				} else {
-					cloudEvent.SetTime(time.Now().UTC())
-				}
-			}
-		}
+		return respond(context, pipeline.ingest(cloudEvent, authenticationHandler.GetPrincipal(context)))
+	}
+}
 
-		// Validate the cloud event has enough information
-		err = cloudEvent.Validate()
-		if err != nil {
-			// TODO :: see if there is a nice way of parsing this
-			errorResult["message"] = "The request does not conform to a valid cloudevent"
-			errorResult["reason"] = "cloud-event-validation"
-			ve := err.(cee.ValidationError)
-			errors := []map[string]string{}
-			for key, value := range ve {
-				errors = append(errors, map[string]string{
-					"attribute": key,
-					"error":     value.Error(),
-				})
-			}
-			errorResult["errors"] = errors
-			return context.Status(fiber.StatusBadRequest).JSON(errorResult)
-		}
+// parseRequest reads a cloud event from either a structured mode (application/cloudevents+json) or
+// a binary mode request
+func parseRequest(context *fiber.Ctx) (cloudevents.Event, *ingestionResult) {
+
+	// Structured mode carries the entire event in the body
+	contentType := context.Get(fiber.HeaderContentType)
+	if contentTypes.MediaType(contentType) == cloudevents.ApplicationCloudEventsJSON {
+		return parseStructuredEvent(context.Body())
+	}
 
-		// Validate that the request matches the defined schema
-		err = eventValidation.Validate(cloudEvent, requestBody)
-		if err != nil {
-			// TODO :: have a global option for allowing unregistered event types
-			validationError, castSuccess := err.(*jsonSchema.ValidationError)
-			if castSuccess {
-				errorResult["message"] = "The specified payload does not match event schema"
-				errorResult["reason"] = "event-validation"
-				errorResult["errors"] = validationError.Causes
-				return context.Status(fiber.StatusBadRequest).JSON(errorResult)
+	// In binary mode the body is the event data, ce-datacontenttype takes precedence over the content type
+	if contentType == "" {
+		contentType = contentTypes.ApplicationJSON
+	}
+
+	cloudEvent := cloudevents.NewEvent()
+	cloudEvent.SetDataContentType(contentType)
+
+	// Map all the headers to the cloud event
+	extensionErrors := []map[string]string{}
+	for key, value := range context.GetReqHeaders() {
+		if strings.EqualFold(key, dataContentTypeHeader) {
+			cloudEvent.SetDataContentType(value)
+		} else if strings.EqualFold(key, dataSchemaHeader) {
+			cloudEvent.SetDataSchema(value)
+		} else if strings.EqualFold(key, idHeader) {
+			cloudEvent.SetID(value)
+		} else if strings.EqualFold(key, sourceHeader) {
+			cloudEvent.SetSource(value)
+		} else if strings.EqualFold(key, specVersionHeader) {
+			cloudEvent.SetSpecVersion(value)
+		} else if strings.EqualFold(key, subjectHeader) {
+			cloudEvent.SetSubject(value)
+		} else if strings.EqualFold(key, typeHeader) {
+			cloudEvent.SetType(value)
+		} else if strings.EqualFold(key, timeHeader) {
+			t, err := time.Parse(time.RFC3339, value)
+			if err == nil {
+				cloudEvent.SetTime(t.UTC())
+			} else {
+				cloudEvent.SetTime(time.Now().UTC())
+			}
+		} else if len(key) > len(extensionHeaderPrefix) && strings.EqualFold(key[:len(extensionHeaderPrefix)], extensionHeaderPrefix) {
+			// Any other ce-* header is an extension attribute
+			name := strings.ToLower(key[len(extensionHeaderPrefix):])
+			err := validateExtensionName(name)
+			if err == nil {
+				cloudEvent.SetExtension(name, value)
 			} else {
-				log.Logger.Error("Unable to validate schema", zap.Error(err))
-				errorResult["reason"] = "event-validation-failure"
-				return context.Status(fiber.StatusBadRequest).JSON(errorResult)
+				extensionErrors = append(extensionErrors, map[string]string{
+					"attribute": name,
+					"error":     err.Error(),
+				})
 			}
 		}
+	}
 
-		// Ensure that we are allowed to ingest the event
-		ingestionDecision, err := ingestionPolicyEngine.GetDecision(cloudEvent, requestBody)
-		if err != nil {
-			log.Logger.Error("Unable to make ingestion decision", zap.Error(err))
-			errorResult["message"] = "Unable to make ingestion decision"
-			errorResult["reason"] = "ingestion-service-failure"
-			return context.Status(fiber.StatusInternalServerError).JSON(errorResult)
-		}
+	if len(extensionErrors) > 0 {
+		result := rejected(fiber.StatusBadRequest, "cloud-event-extension", "One or more extension attributes have an invalid name", extensionErrors)
+		return cloudEvent, &result
+	}
 
-		// Forward the event through to the NATS cluster
-		if ingestionDecision.Allow {
-			if !client.Publish(cloudEvent) {
-				errorResult["reason"] = "publish"
-				return context.Status(fiber.StatusInternalServerError).JSON(errorResult)
-			}
+	return cloudEvent, setRequestData(&cloudEvent, context.Body())
+}
+
+// setRequestData stores the request body as the event data using the data content type of the event. JSON is
+// stored as JSON so that the event is published with "data" rather than "data_base64", any other content type
+// is carried as raw bytes
+func setRequestData(cloudEvent *cloudevents.Event, body []byte) *ingestionResult {
+	if !contentTypes.IsJSON(cloudEvent.DataContentType()) {
+		cloudEvent.SetData(cloudEvent.DataContentType(), body)
+		return nil
+	}
+
+	if !json.Valid(body) {
+		result := rejected(fiber.StatusBadRequest, "request-body", "The event data does not match the data content type", nil)
+		return &result
+	}
+
+	cloudEvent.DataEncoded = body
+	cloudEvent.DataBase64 = false
+	return nil
+}
 
-			context.Status(fiber.StatusAccepted)
-			return nil
+// validateExtensionName applies the CloudEvents naming rules for extension attributes:
+// lower-case ASCII letters and digits only, and not one of the reserved attribute names
+func validateExtensionName(name string) error {
+	for _, c := range name {
+		if !((c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')) {
+			return fmt.Errorf("extension attribute names must consist of lower-case letters ('a' to 'z') or digits ('0' to '9')")
 		}
+	}
+
+	if reservedAttributeNames[name] {
+		return fmt.Errorf("'%s' is a reserved attribute name and cannot be used as an extension", name)
+	}
+
+	return nil
+}
 
-		errorResult["message"] = "The event was rejected by an ingestion policy"
-		errorResult["reason"] = "ingestion-service-rejected"
-		return context.Status(fiber.StatusBadRequest).JSON(errorResult)
+func respond(context *fiber.Ctx, result ingestionResult) error {
+	if result.accepted() && !result.quarantined {
+		context.Status(result.status)
+		return nil
 	}
+
+	return context.Status(result.status).JSON(result.toMap())
+}
+
+// parseStructuredEvent reads a complete cloud event envelope (application/cloudevents+json)
+// including any extensions and data
+func parseStructuredEvent(body []byte) (cloudevents.Event, *ingestionResult) {
+	cloudEvent := cloudevents.NewEvent()
+	err := json.Unmarshal(body, &cloudEvent)
+	if err != nil {
+		log.Logger.Error("Unable to parse structured cloud event", zap.Error(err))
+		result := rejected(fiber.StatusBadRequest, "request-body", "The request is not a valid structured cloudevent", nil)
+		return cloudEvent, &result
+	}
+
+	return cloudEvent, nil
 }
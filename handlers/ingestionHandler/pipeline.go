@@ -0,0 +1,453 @@
+package ingestionHandler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cee "github.com/cloudevents/sdk-go/v2/event"
+	"github.com/gofiber/fiber/v2"
+	"github.com/projectkeas/ingestion/services/authentication"
+	"github.com/projectkeas/ingestion/services/contentTypes"
+	"github.com/projectkeas/ingestion/services/deadLetters"
+	"github.com/projectkeas/ingestion/services/eventPublisher"
+	"github.com/projectkeas/ingestion/services/eventTypes"
+	"github.com/projectkeas/ingestion/services/ingestionPolicies"
+	"github.com/projectkeas/sdks-service/configuration"
+	log "github.com/projectkeas/sdks-service/logger"
+	"github.com/projectkeas/sdks-service/server"
+	jsonSchema "github.com/santhosh-tekuri/jsonschema/v5"
+	"go.uber.org/zap"
+)
+
+// ingestionPipeline runs a single cloud event through validation, the ingestion policies
+// and finally publishes it to the NATS cluster
+type ingestionPipeline struct {
+	eventValidation       eventTypes.EventTypeService
+	ingestionPolicyEngine ingestionPolicies.IngestionPolicyService
+	client                eventPublisher.EventPublisherService
+	deadLetters           deadLetters.DeadLetterService
+	quarantine            atomic.Value // quarantineConfig
+}
+
+type ingestionResult struct {
+	status      int
+	message     string
+	reason      string
+	errors      interface{}
+	policy      string
+	quarantined bool
+	decision    ingestionPolicies.IngestionPolicyDecision
+}
+
+// retentionExtension carries the retention hint from the ingestion policies to downstream consumers
+const retentionExtension = "retention"
+
+func newPipeline(server *server.Server) *ingestionPipeline {
+
+	// payload validation setup
+	eventTypesService, err := server.GetService(eventTypes.SERVICE_NAME)
+	if err != nil {
+		panic(err)
+	}
+
+	// ingestion engine setup
+	ingestionService, err := server.GetService(ingestionPolicies.SERVICE_NAME)
+	if err != nil {
+		panic(err)
+	}
+
+	// publisher setup
+	nc, err := server.GetService(eventPublisher.SERVICE_NAME)
+	if err != nil {
+		panic(err)
+	}
+
+	// dead letter setup
+	dl, err := server.GetService(deadLetters.SERVICE_NAME)
+	if err != nil {
+		panic(err)
+	}
+
+	pipeline := &ingestionPipeline{
+		eventValidation:       (*eventTypesService).(eventTypes.EventTypeService),
+		ingestionPolicyEngine: (*ingestionService).(ingestionPolicies.IngestionPolicyService),
+		client:                (*nc).(eventPublisher.EventPublisherService),
+		deadLetters:           (*dl).(deadLetters.DeadLetterService),
+	}
+
+	server.GetConfiguration().RegisterChangeNotificationHandler(func(config configuration.ConfigurationRoot) {
+		pipeline.quarantine.Store(newQuarantineConfig(config))
+	})
+
+	return pipeline
+}
+
+func (pipeline *ingestionPipeline) ingest(cloudEvent cloudevents.Event, principal *authentication.Principal) ingestionResult {
+	// The copy is evaluated so that a refused event is dead lettered as it was received, replaying the transformed
+	// event would apply the transforms of the policies a second time
+	evaluated := cloudEvent.Clone()
+	result := pipeline.process(&evaluated, principal)
+
+	// Keep a copy of anything that was refused so that it can be audited & replayed
+	if !result.accepted() {
+		pipeline.deadLetters.Publish(cloudEvent, deadLetters.DeadLetter{
+			Reason:    result.reason,
+			Message:   result.message,
+			Errors:    result.errors,
+			Policy:    result.policy,
+			Principal: deadLetterPrincipal(principal),
+		})
+	}
+
+	return result
+}
+
+// deadLetterPrincipal records who sent a dead lettered event so that their scopes still apply when it is replayed
+func deadLetterPrincipal(principal *authentication.Principal) *deadLetters.Principal {
+	if principal == nil {
+		return nil
+	}
+
+	return &deadLetters.Principal{
+		Id:         principal.Id,
+		Tenant:     principal.Tenant,
+		Method:     principal.Method,
+		EventTypes: principal.EventTypes,
+		Sources:    principal.Sources,
+	}
+}
+
+// process evaluates and publishes the event, the event is updated with any changes made during evaluation
+func (pipeline *ingestionPipeline) process(cloudEvent *cloudevents.Event, principal *authentication.Principal) ingestionResult {
+	result := pipeline.evaluate(cloudEvent, principal, false)
+
+	if result.quarantined {
+		return pipeline.publishQuarantined(*cloudEvent, result)
+	}
+
+	if !result.accepted() {
+		return result
+	}
+
+	return pipeline.publish(*cloudEvent, result)
+}
+
+// publish applies the outcome of the ingestion policies to the event and forwards it to the NATS cluster
+func (pipeline *ingestionPipeline) publish(cloudEvent cloudevents.Event, result ingestionResult) ingestionResult {
+	decision := result.decision
+
+	for name, value := range decision.Extensions {
+		err := cloudEvent.Context.SetExtension(name, value)
+		if err != nil {
+			log.Logger.Error("Unable to apply extension from ingestion policy", zap.String("extension", name), zap.Error(err))
+			return rejected(fiber.StatusInternalServerError, "ingestion-service-failure", "Unable to apply the ingestion decision", nil)
+		}
+	}
+
+	if decision.Retention != "" {
+		cloudEvent.SetExtension(retentionExtension, decision.Retention)
+	}
+
+	var published bool
+	if decision.Subject != "" {
+		published = pipeline.client.PublishToSubject(cloudEvent, eventPublisher.StreamForSubject(decision.Subject), decision.Subject)
+	} else {
+		published = pipeline.client.Publish(cloudEvent)
+	}
+
+	if !published {
+		return rejected(fiber.StatusInternalServerError, "publish", "", nil)
+	}
+
+	return result
+}
+
+// validateSubject checks the subject returned by an ingestion policy. The dead letter and quarantine subjects are
+// reserved so that a policy can't forge dead letters that would be replayed
+func (pipeline *ingestionPipeline) validateSubject(subject string) error {
+	err := eventPublisher.ValidateSubject(subject)
+	if err != nil {
+		return err
+	}
+
+	reserved := []string{pipeline.deadLetters.Subject(), pipeline.quarantine.Load().(quarantineConfig).subject}
+	for _, prefix := range reserved {
+		if subject == prefix || strings.HasPrefix(subject, prefix+".") {
+			return fmt.Errorf("the subject %s is reserved", prefix)
+		}
+	}
+
+	return nil
+}
+
+// evaluate runs the validation and ingestion policies against the event without publishing it. The
+// event is updated with any changes made during evaluation, eg: the resolved dataschema or a transformed payload.
+// When explain is set every policy is evaluated and the result of each one is recorded on the decision. The
+// principal is nil when the event isn't sent by an authenticated request, eg: when a dead letter written before
+// the principal was recorded is replayed
+func (pipeline *ingestionPipeline) evaluate(cloudEvent *cloudevents.Event, principal *authentication.Principal, explain bool) ingestionResult {
+
+	// Validate the cloud event has enough information
+	err := cloudEvent.Validate()
+	if err != nil {
+		// TODO :: see if there is a nice way of parsing this
+		validationErrors := []map[string]string{}
+		ve, castSuccess := err.(cee.ValidationError)
+		if castSuccess {
+			for key, value := range ve {
+				validationErrors = append(validationErrors, map[string]string{
+					"attribute": key,
+					"error":     value.Error(),
+				})
+			}
+		}
+		return rejected(fiber.StatusBadRequest, "cloud-event-validation", "The request does not conform to a valid cloudevent", validationErrors)
+	}
+
+	// Ensure that the credentials are allowed to send this event
+	if principal != nil && !principal.Permits(cloudEvent.Type(), cloudEvent.Source()) {
+		log.Logger.Warn("principal is not permitted to send the event", zap.String("principal", principal.Id), zap.String("type", cloudEvent.Type()), zap.String("source", cloudEvent.Source()))
+		return rejected(fiber.StatusForbidden, "principal-scope", "The credentials are not permitted to send events with this type or source", nil)
+	}
+
+	// Decode the payload so that it can be validated & evaluated by the policies
+	requestBody, err := decodePayload(*cloudEvent)
+	if err != nil {
+		log.Logger.Error("Unable to parse event data", zap.Error(err))
+		return rejected(fiber.StatusBadRequest, "request-body", "The event data does not match the data content type", nil)
+	}
+
+	// Events without a dataschema may still identify their schema, eg: via a schema registry id
+	if cloudEvent.DataSchema() == "" {
+		schemaUri, found := pipeline.eventValidation.ResolveDataSchema(*cloudEvent)
+		if found {
+			cloudEvent.SetDataSchema(schemaUri)
+		}
+	}
+
+	// Validate that the request matches the defined schema
+	err = pipeline.eventValidation.Validate(*cloudEvent, requestBody)
+	if errors.Is(err, eventTypes.ErrSchemaNotResolved) {
+		result := pipeline.unknownSchema(rejected(fiber.StatusBadRequest, "event-schema-missing", "The event does not resolve to a registered event type", nil))
+		if result != nil {
+			return pipeline.traceRefused(*result, cloudEvent, requestBody, principal, explain)
+		}
+	} else if errors.Is(err, eventTypes.ErrSchemaNotFound) {
+		result := pipeline.unknownSchema(rejected(fiber.StatusBadRequest, "event-schema-unknown", "The dataschema of the event is not registered", nil))
+		if result != nil {
+			return pipeline.traceRefused(*result, cloudEvent, requestBody, principal, explain)
+		}
+	} else if err != nil {
+		validationError, castSuccess := err.(*jsonSchema.ValidationError)
+		if castSuccess {
+			result := rejected(fiber.StatusBadRequest, "event-validation", "The specified payload does not match event schema", validationError.Causes)
+			return pipeline.traceRefused(result, cloudEvent, requestBody, principal, explain)
+		}
+
+		log.Logger.Error("Unable to validate schema", zap.Error(err))
+		return pipeline.traceRefused(rejected(fiber.StatusBadRequest, "event-validation-failure", "", nil), cloudEvent, requestBody, principal, explain)
+	}
+
+	// Ensure that we are allowed to ingest the event
+	var ingestionDecision ingestionPolicies.IngestionPolicyDecision
+	if explain {
+		ingestionDecision, err = pipeline.ingestionPolicyEngine.Explain(*cloudEvent, requestBody, principal)
+	} else {
+		ingestionDecision, err = pipeline.ingestionPolicyEngine.GetDecision(*cloudEvent, requestBody, principal)
+	}
+	if err != nil {
+		log.Logger.Error("Unable to make ingestion decision", zap.Error(err))
+		return rejected(fiber.StatusInternalServerError, "ingestion-service-failure", "Unable to make ingestion decision", nil)
+	}
+
+	if !ingestionDecision.Allow {
+		var policyErrors interface{}
+		if ingestionDecision.Reason != "" {
+			policyErrors = []map[string]string{
+				{
+					"policy": ingestionDecision.Policy,
+					"reason": ingestionDecision.Reason,
+				},
+			}
+		}
+
+		result := rejected(fiber.StatusBadRequest, "ingestion-service-rejected", "The event was rejected by an ingestion policy", policyErrors)
+		result.policy = ingestionDecision.Policy
+		result.decision = ingestionDecision
+		return result
+	}
+
+	if ingestionDecision.Subject != "" {
+		err := pipeline.validateSubject(ingestionDecision.Subject)
+		if err != nil {
+			log.Logger.Error("Ingestion policy returned an invalid subject", zap.String("subject", ingestionDecision.Subject), zap.Error(err))
+			result := rejected(fiber.StatusInternalServerError, "ingestion-policy-subject", "An ingestion policy returned a subject that the event can't be published to", []map[string]string{
+				{
+					"subject": ingestionDecision.Subject,
+					"reason":  err.Error(),
+				},
+			})
+			result.decision = ingestionDecision
+			return result
+		}
+	}
+
+	// Replace the payload with the one returned by the policies, eg: with PII removed and ensure
+	// that it still matches the schema
+	if ingestionDecision.Transformed {
+		failure := pipeline.applyTransform(cloudEvent, ingestionDecision)
+		if failure != nil {
+			failure.decision = ingestionDecision
+			return *failure
+		}
+	}
+
+	return ingestionResult{
+		status:   fiber.StatusAccepted,
+		decision: ingestionDecision,
+	}
+}
+
+// traceRefused still evaluates every ingestion policy when explaining an event that was refused by schema validation,
+// so that the explanation shows how the policies would treat the event. The verdict is unchanged
+func (pipeline *ingestionPipeline) traceRefused(result ingestionResult, cloudEvent *cloudevents.Event, requestBody interface{}, principal *authentication.Principal, explain bool) ingestionResult {
+	if !explain {
+		return result
+	}
+
+	decision, err := pipeline.ingestionPolicyEngine.Explain(*cloudEvent, requestBody, principal)
+	if err != nil {
+		log.Logger.Error("Unable to explain ingestion decision", zap.Error(err))
+		return result
+	}
+
+	result.decision.Evaluations = decision.Evaluations
+	return result
+}
+
+// applyTransform replaces the event data with the payload returned by the ingestion policies and
+// validates it against the schema again
+func (pipeline *ingestionPipeline) applyTransform(cloudEvent *cloudevents.Event, ingestionDecision ingestionPolicies.IngestionPolicyDecision) *ingestionResult {
+	if !contentTypes.IsJSON(cloudEvent.DataContentType()) {
+		log.Logger.Error("Ingestion policies can only transform JSON payloads", zap.String("datacontenttype", cloudEvent.DataContentType()))
+		failure := rejected(fiber.StatusInternalServerError, "ingestion-service-failure", "Ingestion policies can only transform JSON payloads", nil)
+		return &failure
+	}
+
+	err := cloudEvent.SetData(cloudEvent.DataContentType(), ingestionDecision.Payload)
+	if err != nil {
+		log.Logger.Error("Unable to apply transformed payload", zap.Error(err))
+		failure := rejected(fiber.StatusInternalServerError, "ingestion-service-failure", "Unable to apply the transformed payload", nil)
+		return &failure
+	}
+
+	err = pipeline.eventValidation.Validate(*cloudEvent, ingestionDecision.Payload)
+	if err != nil && !errors.Is(err, eventTypes.ErrSchemaNotResolved) && !errors.Is(err, eventTypes.ErrSchemaNotFound) {
+		var validationErrors interface{}
+		validationError, castSuccess := err.(*jsonSchema.ValidationError)
+		if castSuccess {
+			validationErrors = validationError.Causes
+		}
+		failure := rejected(fiber.StatusInternalServerError, "ingestion-transform-validation", "The payload transformed by an ingestion policy does not match event schema", validationErrors)
+		return &failure
+	}
+
+	return nil
+}
+
+// unknownSchema applies the configured policy (ingestion.schema.unknown) to events without a registered schema.
+// A nil result means that the event is allowed to continue without schema validation
+func (pipeline *ingestionPipeline) unknownSchema(result ingestionResult) *ingestionResult {
+	switch pipeline.quarantine.Load().(quarantineConfig).unknownSchema {
+	case unknownSchemaAllow:
+		return nil
+	case unknownSchemaQuarantine:
+		result.status = fiber.StatusAccepted
+		result.message = "The event was quarantined as it does not have a registered schema"
+		result.quarantined = true
+		return &result
+	default:
+		return &result
+	}
+}
+
+func (pipeline *ingestionPipeline) publishQuarantined(cloudEvent cloudevents.Event, result ingestionResult) ingestionResult {
+	config := pipeline.quarantine.Load().(quarantineConfig)
+
+	envelope, err := deadLetters.NewEnvelope(quarantineEventType, cloudEvent, deadLetters.DeadLetter{
+		Reason:  result.reason,
+		Message: result.message,
+		Errors:  result.errors,
+	})
+	if err != nil {
+		log.Logger.Error("Unable to create quarantine event", zap.Error(err))
+		return rejected(fiber.StatusInternalServerError, "publish", "", nil)
+	}
+
+	if !pipeline.client.PublishToSubject(envelope, config.streamName, config.subject) {
+		return rejected(fiber.StatusInternalServerError, "publish", "", nil)
+	}
+
+	return result
+}
+
+// decodePayload converts the event data into a form that can be validated and evaluated by the ingestion
+// policies. JSON data is decoded, text is returned as a string and any other data is returned as raw bytes
+func decodePayload(cloudEvent cloudevents.Event) (interface{}, error) {
+	data := cloudEvent.Data()
+	contentType := cloudEvent.DataContentType()
+
+	if contentTypes.IsJSON(contentType) {
+		if len(data) == 0 {
+			return nil, nil
+		}
+
+		var result interface{}
+		err := json.Unmarshal(data, &result)
+		return result, err
+	}
+
+	if contentTypes.IsText(contentType) {
+		return string(data), nil
+	}
+
+	return data, nil
+}
+
+func rejected(status int, reason string, message string, errors interface{}) ingestionResult {
+	if message == "" {
+		message = "An error occurred whilst processing your request"
+	}
+
+	return ingestionResult{
+		status:  status,
+		message: message,
+		reason:  reason,
+		errors:  errors,
+	}
+}
+
+func (result ingestionResult) accepted() bool {
+	return result.reason == "" || result.quarantined
+}
+
+func (result ingestionResult) toMap() map[string]interface{} {
+	response := map[string]interface{}{
+		"message": result.message,
+		"reason":  result.reason,
+	}
+
+	if result.errors != nil {
+		response["errors"] = result.errors
+	}
+
+	if result.policy != "" {
+		response["policy"] = result.policy
+	}
+
+	return response
+}
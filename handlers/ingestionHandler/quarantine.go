@@ -0,0 +1,27 @@
+package ingestionHandler
+
+import (
+	"github.com/projectkeas/sdks-service/configuration"
+)
+
+const (
+	unknownSchemaReject     string = "reject"
+	unknownSchemaAllow      string = "allow"
+	unknownSchemaQuarantine string = "quarantine"
+
+	quarantineEventType string = "io.keas.ingestion.quarantined"
+)
+
+type quarantineConfig struct {
+	unknownSchema string
+	streamName    string
+	subject       string
+}
+
+func newQuarantineConfig(config configuration.ConfigurationRoot) quarantineConfig {
+	return quarantineConfig{
+		unknownSchema: config.GetStringValueOrDefault("ingestion.schema.unknown", unknownSchemaReject),
+		streamName:    config.GetStringValueOrDefault("ingestion.schema.quarantine.stream", "ingestion"),
+		subject:       config.GetStringValueOrDefault("ingestion.schema.quarantine.subject", "ingestion.quarantine"),
+	}
+}
@@ -0,0 +1,171 @@
+package ingestionHandler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/projectkeas/ingestion/services/authentication"
+	"github.com/projectkeas/ingestion/services/deadLetters"
+	log "github.com/projectkeas/sdks-service/logger"
+	"github.com/projectkeas/sdks-service/server"
+	"go.uber.org/zap"
+)
+
+type replayRequest struct {
+	Reasons []string  `json:"reasons"`
+	Types   []string  `json:"types"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	DryRun  bool      `json:"dryRun"`
+	Limit   int       `json:"limit"`
+}
+
+const defaultReplayLimit = 1000
+
+// NewReplay reads events from the dead letter stream, re-runs them through validation and the ingestion
+// policies using the current EventTypes & IngestionPolicies and republishes those that now pass. Replayed
+// events are removed from the dead letter stream
+func NewReplay(server *server.Server) func(context *fiber.Ctx) error {
+
+	pipeline := newPipeline(server)
+
+	// Only a single replay runs at a time so that a retried request can't read the same dead letters
+	// before the first request has removed them
+	replaying := sync.Mutex{}
+
+	return func(context *fiber.Ctx) error {
+		context.Accepts("application/json")
+		errorResult := map[string]interface{}{
+			"message": "An error occurred whilst processing your request",
+		}
+
+		request := replayRequest{}
+		if len(context.Body()) > 0 {
+			err := context.BodyParser(&request)
+			if err != nil {
+				log.Logger.Error("Unable to parse request body", zap.Error(err))
+				errorResult["reason"] = "request-body"
+				return context.Status(fiber.StatusBadRequest).JSON(errorResult)
+			}
+		}
+
+		if !replaying.TryLock() {
+			errorResult["message"] = "A replay is already in progress"
+			errorResult["reason"] = "replay-in-progress"
+			return context.Status(fiber.StatusConflict).JSON(errorResult)
+		}
+		defer replaying.Unlock()
+
+		if request.Limit <= 0 {
+			request.Limit = defaultReplayLimit
+		}
+
+		reasons := toSet(request.Reasons)
+		types := toSet(request.Types)
+
+		scanned, replayed, rejectedCount, quarantined, failed := 0, 0, 0, 0, 0
+		results := []map[string]interface{}{}
+
+		err := pipeline.deadLetters.Read(deadLetters.ReadOptions{
+			From: request.From,
+			To:   request.To,
+		}, func(record deadLetters.Record) bool {
+			scanned++
+
+			if len(reasons) > 0 && !reasons[record.Reason] {
+				return true
+			}
+			if len(types) > 0 && !types[record.Event.Type()] {
+				return true
+			}
+
+			response := map[string]interface{}{
+				"sequence":       record.Sequence,
+				"id":             record.Event.ID(),
+				"type":           record.Event.Type(),
+				"originalReason": record.Reason,
+			}
+
+			// The event is evaluated as the principal that sent it so that the same scopes & policies apply
+			result := pipeline.evaluate(&record.Event, replayPrincipal(record.Principal), false)
+			if result.accepted() && !result.quarantined {
+				if request.DryRun {
+					response["status"] = "replayable"
+					replayed++
+				} else if pipeline.publish(record.Event, result).accepted() {
+					response["status"] = "replayed"
+					replayed++
+
+					// Remove the dead letter so that running the same replay again doesn't publish the event twice
+					err := record.Remove()
+					if err != nil {
+						log.Logger.Error("Unable to remove replayed dead letter", zap.Uint64("sequence", record.Sequence), zap.Error(err))
+						response["removed"] = false
+					}
+				} else {
+					response["status"] = "failed"
+					failed++
+				}
+			} else {
+				// Events that would now be quarantined stay in the dead letter stream, they are reported separately
+				// from the events that are denied so that operators can tell them apart
+				response["status"] = "rejected"
+				if result.quarantined {
+					response["status"] = "quarantined"
+					quarantined++
+				} else {
+					rejectedCount++
+				}
+				for key, value := range result.toMap() {
+					response[key] = value
+				}
+			}
+
+			results = append(results, response)
+			return len(results) < request.Limit
+		})
+
+		if err != nil {
+			log.Logger.Error("Unable to read dead letters", zap.Error(err))
+			errorResult["message"] = "Unable to read from the dead letter stream"
+			errorResult["reason"] = "dead-letter-read"
+			return context.Status(fiber.StatusInternalServerError).JSON(errorResult)
+		}
+
+		return context.Status(fiber.StatusOK).JSON(map[string]interface{}{
+			"dryRun":      request.DryRun,
+			"scanned":     scanned,
+			"matched":     len(results),
+			"replayed":    replayed,
+			"rejected":    rejectedCount,
+			"quarantined": quarantined,
+			"failed":      failed,
+			"results":     results,
+		})
+	}
+}
+
+func toSet(values []string) map[string]bool {
+	result := map[string]bool{}
+	for _, value := range values {
+		result[value] = true
+	}
+	return result
+}
+
+// replayPrincipal restores the principal that sent a dead lettered event, replayed events are never sent
+// with admin rights
+func replayPrincipal(principal *deadLetters.Principal) *authentication.Principal {
+	if principal == nil {
+		return nil
+	}
+
+	return &authentication.Principal{
+		Id:         principal.Id,
+		Tenant:     principal.Tenant,
+		Method:     principal.Method,
+		EventTypes: principal.EventTypes,
+		Sources:    principal.Sources,
+	}
+}
@@ -0,0 +1,114 @@
+package ingestionHandler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/projectkeas/ingestion/services/authentication"
+	"github.com/projectkeas/ingestion/services/contentTypes"
+	log "github.com/projectkeas/sdks-service/logger"
+	"github.com/projectkeas/sdks-service/server"
+	"go.uber.org/zap"
+)
+
+// NewWebhook accepts webhooks from third party providers that can't send cloud events. The request is
+// authenticated by the signature of the provider configured in ingestion.webhooks.providers instead of
+// the Authorization header and the payload is mapped into a cloud event before running the same pipeline
+// as a single event.
+func NewWebhook(server *server.Server) func(context *fiber.Ctx) error {
+
+	pipeline := newPipeline(server)
+
+	service, err := server.GetService(authentication.WEBHOOK_SERVICE_NAME)
+	if err != nil {
+		panic(err)
+	}
+	providers := (*service).(authentication.WebhookProviders)
+
+	return func(context *fiber.Ctx) error {
+		context.Accepts("application/json")
+
+		provider, found := providers.Get(context.Params("provider"))
+		if !found {
+			return context.SendStatus(fiber.StatusNotFound)
+		}
+
+		header := func(name string) string { return context.Get(name) }
+		err := provider.Verify(header, context.Body())
+		if err != nil {
+			log.Logger.Info("webhook signature rejected", zap.String("provider", provider.Name), zap.Error(err))
+			return context.SendStatus(fiber.StatusUnauthorized)
+		}
+
+		cloudEvent, errorResult := mapWebhook(context, provider)
+		if errorResult != nil {
+			return context.Status(errorResult.status).JSON(errorResult.toMap())
+		}
+
+		principal := provider.Principal()
+		return respond(context, pipeline.ingest(cloudEvent, &principal))
+	}
+}
+
+// mapWebhook creates a cloud event from the webhook payload using the event and delivery headers or fields
+// of the provider, the body becomes the event data
+func mapWebhook(context *fiber.Ctx, provider authentication.WebhookProvider) (cloudevents.Event, *ingestionResult) {
+	contentType := context.Get(fiber.HeaderContentType)
+	if contentType == "" {
+		contentType = contentTypes.ApplicationJSON
+	}
+
+	// Providers that don't send the event type in a header have it in the body, eg: Stripe
+	fields := map[string]interface{}{}
+	if provider.EventField != "" || provider.IdField != "" {
+		_ = json.Unmarshal(context.Body(), &fields)
+	}
+
+	eventType := webhookValue(context, provider.EventHeader, fields, provider.EventField)
+	id := webhookValue(context, provider.DeliveryHeader, fields, provider.IdField)
+
+	cloudEvent := cloudevents.NewEvent()
+	cloudEvent.SetSource(provider.Source)
+	cloudEvent.SetTime(time.Now().UTC())
+	cloudEvent.SetDataContentType(contentType)
+
+	errorResult := setRequestData(&cloudEvent, context.Body())
+	if errorResult != nil {
+		return cloudEvent, errorResult
+	}
+
+	if eventType == "" {
+		result := rejected(fiber.StatusBadRequest, "webhook-event", "The event type could not be read from the webhook", nil)
+		return cloudEvent, &result
+	}
+	cloudEvent.SetType(provider.TypePrefix + eventType)
+
+	// Without a delivery id the hash of the body gives retries of the same webhook the same id
+	if id == "" {
+		hash := sha256.Sum256(context.Body())
+		id = hex.EncodeToString(hash[:])
+	}
+	cloudEvent.SetID(id)
+
+	return cloudEvent, nil
+}
+
+func webhookValue(context *fiber.Ctx, header string, fields map[string]interface{}, field string) string {
+	if header != "" {
+		value := context.Get(header)
+		if value != "" {
+			return value
+		}
+	}
+
+	if field != "" && fields[field] != nil {
+		return fmt.Sprint(fields[field])
+	}
+
+	return ""
+}
@@ -0,0 +1,27 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Revision identifies the parts of a resource that change how it is compiled, the generation of the spec
+// and the annotations. Updates that only change the status or the ResourceVersion, eg: our own status updates
+// and resyncs, keep the same revision so they don't cause the resource to be compiled again.
+func Revision(resource metav1.Object) string {
+	if resource.GetGeneration() == 0 {
+		// The API server didn't set a generation so we can't tell what has changed
+		return resource.GetResourceVersion()
+	}
+
+	annotations := make([]string, 0, len(resource.GetAnnotations()))
+	for key, value := range resource.GetAnnotations() {
+		annotations = append(annotations, key+"="+value)
+	}
+	sort.Strings(annotations)
+
+	return fmt.Sprintf("%d|%s", resource.GetGeneration(), strings.Join(annotations, ","))
+}
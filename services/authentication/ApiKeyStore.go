@@ -0,0 +1,217 @@
+package authentication
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"sync/atomic"
+	"time"
+
+	"github.com/projectkeas/sdks-service/configuration"
+	log "github.com/projectkeas/sdks-service/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	SERVICE_NAME string = "ApiKeys"
+
+	// DefaultKeyId is the id of the key configured with the legacy ingestion.auth.token setting
+	DefaultKeyId string = "default"
+)
+
+type ApiKeyStore interface {
+	// Authenticate returns the principal that owns the key, the key must exist and be enabled
+	Authenticate(key string) (Principal, bool)
+}
+
+// apiKey is the configuration of a single key in ingestion.auth.keys
+type apiKey struct {
+	Id          string     `json:"id"`
+	Tenant      string     `json:"tenant"`
+	Key         string     `json:"key"`
+	EventTypes  []string   `json:"eventTypes"`
+	Sources     []string   `json:"sources"`
+	Enabled     *bool      `json:"enabled"`
+	Admin       bool       `json:"admin"`
+	NotBefore   *time.Time `json:"notBefore"`
+	Expires     *time.Time `json:"expires"`
+	GracePeriod string     `json:"gracePeriod"`
+}
+
+type storedKey struct {
+	hash      [sha256.Size]byte
+	principal Principal
+	notBefore time.Time
+	expires   time.Time
+	// grace is how long the key is still accepted after it expires, so that producers can be migrated to a
+	// new key without an outage
+	grace time.Duration
+}
+
+var (
+	// keyRequests counts the requests authenticated by each key id
+	keyRequests = expvar.NewMap("ingestion_auth_key_requests")
+	// keyGraceRequests counts the requests authenticated by each key id after the key has expired
+	keyGraceRequests = expvar.NewMap("ingestion_auth_key_grace_requests")
+	// keyRejections counts the requests using a key that isn't valid yet or has expired by key id
+	keyRejections = expvar.NewMap("ingestion_auth_key_rejections")
+)
+
+// apiKeyStore serves lookups from an immutable snapshot of the keys which is replaced when the configuration changes
+type apiKeyStore struct {
+	keys atomic.Value // []storedKey
+}
+
+func New(config *configuration.ConfigurationRoot) ApiKeyStore {
+	store := &apiKeyStore{}
+	store.keys.Store([]storedKey{})
+
+	config.RegisterChangeNotificationHandler(func(c configuration.ConfigurationRoot) {
+		keys, err := loadKeys(c)
+		if err != nil {
+			log.Logger.Error("unable to parse ingestion.auth.keys, the previous keys remain in effect", zap.Error(err))
+			return
+		}
+		store.keys.Store(keys)
+	})
+
+	return store
+}
+
+func (store *apiKeyStore) Authenticate(key string) (Principal, bool) {
+	if key == "" {
+		return Principal{}, false
+	}
+
+	// Compare hashes in constant time so that the comparison doesn't leak how much of a key matched
+	hash := sha256.Sum256([]byte(key))
+	for _, stored := range store.keys.Load().([]storedKey) {
+		if subtle.ConstantTimeCompare(hash[:], stored.hash[:]) == 1 {
+			return stored.authenticate(time.Now())
+		}
+	}
+
+	return Principal{}, false
+}
+
+// authenticate checks that the key is valid at the time and records which key was used
+func (stored storedKey) authenticate(now time.Time) (Principal, bool) {
+	id := stored.principal.Id
+
+	if !stored.notBefore.IsZero() && now.Before(stored.notBefore) {
+		keyRejections.Add(id, 1)
+		log.Logger.Warn("api key used before it is valid", zap.String("keyId", id), zap.Time("notBefore", stored.notBefore))
+		return Principal{}, false
+	}
+
+	if !stored.expires.IsZero() && now.After(stored.expires) {
+		if now.After(stored.expires.Add(stored.grace)) {
+			keyRejections.Add(id, 1)
+			log.Logger.Warn("expired api key used", zap.String("keyId", id), zap.Time("expires", stored.expires))
+			return Principal{}, false
+		}
+
+		keyGraceRequests.Add(id, 1)
+		log.Logger.Warn("expired api key used within its grace period, the producer must move to a new key", zap.String("keyId", id), zap.Time("expires", stored.expires), zap.Time("rejectedFrom", stored.expires.Add(stored.grace)))
+	}
+
+	keyRequests.Add(id, 1)
+	log.Logger.Debug("authenticated request with api key", zap.String("keyId", id), zap.String("tenant", stored.principal.Tenant))
+	return stored.principal, true
+}
+
+// loadKeys reads the legacy token and ingestion.auth.keys, invalid keys are skipped so that a mistake in one
+// key doesn't revoke the others. An error is returned when ingestion.auth.keys isn't a JSON array
+func loadKeys(config configuration.ConfigurationRoot) ([]storedKey, error) {
+	result := []storedKey{}
+
+	// The legacy token remains valid as an unrestricted key so that existing producers keep working, it can only
+	// use the administrative endpoints when ingestion.auth.tokenAdmin is set
+	token := config.GetStringValueOrDefault("ingestion.auth.token", "")
+	if token != "" {
+		result = append(result, storedKey{
+			hash: sha256.Sum256([]byte(token)),
+			principal: Principal{
+				Id:     DefaultKeyId,
+				Tenant: DefaultKeyId,
+				Method: MethodApiKey,
+				Admin:  config.GetBooleanValueOrDefault("ingestion.auth.tokenAdmin", false),
+			},
+		})
+	}
+
+	value := config.GetStringValueOrDefault("ingestion.auth.keys", "")
+	if value == "" {
+		return result, nil
+	}
+
+	entries := []json.RawMessage{}
+	err := json.Unmarshal([]byte(value), &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultGrace, err := time.ParseDuration(config.GetStringValueOrDefault("ingestion.auth.gracePeriod", "0s"))
+	if err != nil {
+		log.Logger.Error("unable to parse ingestion.auth.gracePeriod, expired keys have no grace period", zap.Error(err))
+		defaultGrace = 0
+	}
+
+	ids := map[string]bool{}
+	for index, entry := range entries {
+		key := apiKey{}
+		err := json.Unmarshal(entry, &key)
+		if err != nil {
+			log.Logger.Error("ignoring api key that can't be parsed", zap.Int("index", index), zap.Error(err))
+			continue
+		}
+
+		if key.Id == "" || key.Key == "" {
+			log.Logger.Error("ignoring api key without an id or key", zap.String("id", key.Id))
+			continue
+		}
+		if ids[key.Id] {
+			log.Logger.Error("ignoring api key with a duplicate id", zap.String("id", key.Id))
+			continue
+		}
+		ids[key.Id] = true
+
+		if key.Enabled != nil && !*key.Enabled {
+			continue
+		}
+
+		grace := defaultGrace
+		if key.GracePeriod != "" {
+			grace, err = time.ParseDuration(key.GracePeriod)
+			if err != nil {
+				log.Logger.Error("ignoring api key with an invalid grace period", zap.String("id", key.Id), zap.Error(err))
+				continue
+			}
+		}
+
+		stored := storedKey{
+			hash:  sha256.Sum256([]byte(key.Key)),
+			grace: grace,
+			principal: Principal{
+				Id:         key.Id,
+				Tenant:     key.Tenant,
+				Method:     MethodApiKey,
+				EventTypes: key.EventTypes,
+				Sources:    key.Sources,
+				Admin:      key.Admin,
+			},
+		}
+		if key.NotBefore != nil {
+			stored.notBefore = *key.NotBefore
+		}
+		if key.Expires != nil {
+			stored.expires = *key.Expires
+		}
+
+		result = append(result, stored)
+	}
+
+	log.Logger.Info("loaded api keys", zap.Int("keys", len(result)))
+	return result, nil
+}
@@ -0,0 +1,141 @@
+package authentication
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"path"
+	"sync/atomic"
+
+	"github.com/projectkeas/sdks-service/configuration"
+	log "github.com/projectkeas/sdks-service/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	CERTIFICATE_SERVICE_NAME string = "ClientCertificates"
+
+	MethodMtls string = "mtls"
+)
+
+type ClientCertificateAuthenticator interface {
+	// Authenticate returns the principal bound to the identity of a verified client certificate
+	Authenticate(certificate *x509.Certificate) (Principal, bool)
+}
+
+// identityBinding is the configuration of a single identity in ingestion.auth.mtls.identities
+type identityBinding struct {
+	// Identity is a glob matched against the identity of the certificate, eg: spiffe://example.org/ns/orders/sa/*
+	Identity   string   `json:"identity"`
+	Tenant     string   `json:"tenant"`
+	EventTypes []string `json:"eventTypes"`
+	Sources    []string `json:"sources"`
+	Enabled    *bool    `json:"enabled"`
+}
+
+type certificateSettings struct {
+	bindings []identityBinding
+	// allowUnbound accepts certificates whose identity doesn't match a binding, they may only send events
+	// from sources starting with their identity
+	allowUnbound bool
+}
+
+type clientCertificateAuthenticator struct {
+	settings atomic.Value // certificateSettings
+}
+
+func NewClientCertificates(config *configuration.ConfigurationRoot) ClientCertificateAuthenticator {
+	authenticator := &clientCertificateAuthenticator{}
+	authenticator.settings.Store(certificateSettings{})
+
+	config.RegisterChangeNotificationHandler(func(c configuration.ConfigurationRoot) {
+		settings := certificateSettings{
+			bindings:     []identityBinding{},
+			allowUnbound: c.GetBooleanValueOrDefault("ingestion.auth.mtls.allowUnbound", false),
+		}
+
+		value := c.GetStringValueOrDefault("ingestion.auth.mtls.identities", "")
+		if value != "" {
+			bindings := []identityBinding{}
+			err := json.Unmarshal([]byte(value), &bindings)
+			if err != nil {
+				log.Logger.Error("unable to parse ingestion.auth.mtls.identities, keeping the previous identities", zap.Error(err))
+				settings.bindings = authenticator.settings.Load().(certificateSettings).bindings
+			} else {
+				for _, binding := range bindings {
+					if binding.Identity == "" {
+						log.Logger.Warn("ignoring an mtls identity without an identity")
+						continue
+					}
+					if _, err := path.Match(binding.Identity, ""); err != nil {
+						log.Logger.Warn("ignoring an mtls identity with an invalid pattern", zap.String("identity", binding.Identity), zap.Error(err))
+						continue
+					}
+					settings.bindings = append(settings.bindings, binding)
+				}
+			}
+		}
+
+		authenticator.settings.Store(settings)
+	})
+
+	return authenticator
+}
+
+func (authenticator *clientCertificateAuthenticator) Authenticate(certificate *x509.Certificate) (Principal, bool) {
+	identity := CertificateIdentity(certificate)
+	if identity == "" {
+		log.Logger.Warn("client certificate does not have an identity")
+		return Principal{}, false
+	}
+
+	settings := authenticator.settings.Load().(certificateSettings)
+
+	// The first matching binding wins so specific identities should be listed before wildcards
+	for _, binding := range settings.bindings {
+		matched, err := path.Match(binding.Identity, identity)
+		if err != nil || !matched {
+			continue
+		}
+
+		if binding.Enabled != nil && !*binding.Enabled {
+			log.Logger.Warn("client certificate identity is disabled", zap.String("identity", identity))
+			return Principal{}, false
+		}
+
+		log.Logger.Debug("authenticated request with client certificate", zap.String("identity", identity), zap.String("tenant", binding.Tenant))
+		return Principal{
+			Id:         identity,
+			Tenant:     binding.Tenant,
+			Method:     MethodMtls,
+			EventTypes: binding.EventTypes,
+			Sources:    binding.Sources,
+		}, true
+	}
+
+	if !settings.allowUnbound {
+		log.Logger.Warn("client certificate identity is not bound in ingestion.auth.mtls.identities", zap.String("identity", identity))
+		return Principal{}, false
+	}
+
+	// Bind the identity to its own sources so that an unbound certificate can't spoof the source of another service
+	return Principal{
+		Id:      identity,
+		Method:  MethodMtls,
+		Sources: []string{identity},
+	}, true
+}
+
+// CertificateIdentity is the identity of a client certificate, the first URI SAN (eg: a SPIFFE id) followed by
+// the first DNS SAN and then the subject common name
+func CertificateIdentity(certificate *x509.Certificate) string {
+	if certificate == nil {
+		return ""
+	}
+	if len(certificate.URIs) > 0 {
+		return certificate.URIs[0].String()
+	}
+	if len(certificate.DNSNames) > 0 {
+		return certificate.DNSNames[0]
+	}
+	return certificate.Subject.CommonName
+}
@@ -0,0 +1,253 @@
+package authentication
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/projectkeas/sdks-service/logger"
+	"go.uber.org/zap"
+)
+
+// minimumJwksRefresh limits how often an unknown key id or a failed fetch can cause the JWKS to be fetched again
+const minimumJwksRefresh = time.Minute
+
+var jwksClient = &http.Client{Timeout: 10 * time.Second}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwksCache holds the signing keys from a JWKS file or URL. The keys are fetched again when they are older
+// than the refresh interval or when a token is signed with a key id that isn't known, eg: after the identity
+// provider rotated its keys. Only one fetch runs at a time and the cached keys are used whilst it runs, requests
+// only wait for it when there aren't any keys yet or the key id is unknown
+type jwksCache struct {
+	location  string
+	refresh   time.Duration
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// attemptedAt is when the last fetch completed, successful or not
+	attemptedAt time.Time
+	// fetching is closed when the fetch in progress completes, nil when the keys aren't being fetched
+	fetching chan struct{}
+	// fetchErr is the error of the last fetch
+	fetchErr error
+	lock     sync.Mutex
+}
+
+func newJwksCache(location string, refresh time.Duration) *jwksCache {
+	return &jwksCache{
+		location: location,
+		refresh:  refresh,
+	}
+}
+
+func (cache *jwksCache) key(kid string) (crypto.PublicKey, error) {
+	cache.lock.Lock()
+	key, found := cache.keys[kid]
+	stale := cache.keys == nil || time.Since(cache.fetchedAt) > cache.refresh || !found
+	if !stale || time.Since(cache.attemptedAt) <= minimumJwksRefresh {
+		// The last fetch was too recent to try again, even if it failed
+		keys, err := cache.keys, cache.fetchErr
+		cache.lock.Unlock()
+		if keys == nil {
+			return nil, err
+		}
+		return knownKey(kid, key, found)
+	}
+
+	done := cache.fetch()
+	cache.lock.Unlock()
+
+	// Keep using the keys we already have whilst they are refreshed rather than holding up every request
+	if found {
+		return key, nil
+	}
+
+	<-done
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if cache.keys == nil {
+		return nil, cache.fetchErr
+	}
+	key, found = cache.keys[kid]
+	return knownKey(kid, key, found)
+}
+
+func knownKey(kid string, key crypto.PublicKey, found bool) (crypto.PublicKey, error) {
+	if !found {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+	return key, nil
+}
+
+// fetch starts fetching the keys unless a fetch is already running, the lock must be held. The channel is
+// closed once the keys have been fetched
+func (cache *jwksCache) fetch() chan struct{} {
+	if cache.fetching != nil {
+		return cache.fetching
+	}
+
+	done := make(chan struct{})
+	cache.fetching = done
+
+	go func() {
+		keys, err := fetchJwks(cache.location)
+
+		cache.lock.Lock()
+		if err != nil {
+			// Keep using the keys we already have rather than failing every request
+			log.Logger.Warn("unable to fetch the JWKS", zap.String("jwks", cache.location), zap.Error(err))
+		} else {
+			cache.keys = keys
+			cache.fetchedAt = time.Now()
+		}
+		cache.attemptedAt = time.Now()
+		cache.fetchErr = err
+		cache.fetching = nil
+		cache.lock.Unlock()
+
+		close(done)
+	}()
+
+	return done
+}
+
+func fetchJwks(location string) (map[string]crypto.PublicKey, error) {
+	var data []byte
+	var err error
+
+	if strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://") {
+		response, err := jwksClient.Get(location)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unable to fetch the JWKS, status code: %d", response.StatusCode)
+		}
+		data, err = io.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		data, err = os.ReadFile(strings.TrimPrefix(location, "file://"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return parseJwks(data)
+}
+
+// parseJwks reads the RSA & EC signing keys from a JSON Web Key Set (RFC 7517), other keys are ignored. Keys that
+// can't be read are logged and skipped so that one unsupported key doesn't disable the others, an error is only
+// returned when there aren't any usable keys
+func parseJwks(data []byte) (map[string]crypto.PublicKey, error) {
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		var key crypto.PublicKey
+		switch jwk.Kty {
+		case "RSA":
+			key, err = parseRsaKey(jwk)
+		case "EC":
+			key, err = parseEcKey(jwk)
+		default:
+			continue
+		}
+
+		if err != nil {
+			log.Logger.Warn("ignoring invalid key in the JWKS", zap.String("kid", jwk.Kid), zap.String("kty", jwk.Kty), zap.Error(err))
+			continue
+		}
+		result[jwk.Kid] = key
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("the JWKS does not have any usable signing keys")
+	}
+	return result, nil
+}
+
+func parseRsaKey(jwk jsonWebKey) (crypto.PublicKey, error) {
+	n, err := decodeBigInt(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeBigInt(jwk.E)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func parseEcKey(jwk jsonWebKey) (crypto.PublicKey, error) {
+	var curve elliptic.Curve
+	switch jwk.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+	}
+
+	x, err := decodeBigInt(jwk.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeBigInt(jwk.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	if !curve.IsOnCurve(x, y) {
+		return nil, fmt.Errorf("the point is not on the curve %s", jwk.Crv)
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
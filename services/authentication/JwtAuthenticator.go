@@ -0,0 +1,192 @@
+package authentication
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/projectkeas/sdks-service/configuration"
+	log "github.com/projectkeas/sdks-service/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	JWT_SERVICE_NAME string = "Jwt"
+
+	MethodJwt string = "jwt"
+)
+
+var (
+	// ErrJwtDisabled is returned when a bearer token is used but ingestion.auth.jwt.enabled isn't set
+	ErrJwtDisabled = errors.New("bearer token authentication is not enabled")
+
+	// jwtMethods are the asymmetric signing algorithms accepted, symmetric algorithms are never accepted as
+	// the JWKS only contains public keys
+	jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+)
+
+type JwtAuthenticator interface {
+	// Authenticate validates the bearer token and returns the principal described by its claims
+	Authenticate(token string) (Principal, error)
+}
+
+type jwtSettings struct {
+	enabled         bool
+	issuer          string
+	audience        string
+	jwks            *jwksCache
+	claimId         string
+	claimTenant     string
+	claimEventTypes string
+	claimSources    string
+	// defaultEventTypes & defaultSources scope the tokens that don't have the event types or sources claims
+	defaultEventTypes []string
+	defaultSources    []string
+}
+
+type jwtAuthenticator struct {
+	settings atomic.Value // jwtSettings
+}
+
+func NewJwt(config *configuration.ConfigurationRoot) JwtAuthenticator {
+	authenticator := &jwtAuthenticator{}
+	authenticator.settings.Store(jwtSettings{})
+
+	config.RegisterChangeNotificationHandler(func(c configuration.ConfigurationRoot) {
+		current := authenticator.settings.Load().(jwtSettings)
+
+		refresh, err := time.ParseDuration(c.GetStringValueOrDefault("ingestion.auth.jwt.refreshInterval", "15m"))
+		if err != nil {
+			log.Logger.Error("unable to parse ingestion.auth.jwt.refreshInterval, using 15m", zap.Error(err))
+			refresh = 15 * time.Minute
+		}
+
+		// Keep the cached keys unless the JWKS has changed
+		location := c.GetStringValueOrDefault("ingestion.auth.jwt.jwks", "")
+		jwks := current.jwks
+		if jwks == nil || jwks.location != location || jwks.refresh != refresh {
+			jwks = newJwksCache(location, refresh)
+		}
+
+		settings := jwtSettings{
+			enabled:         c.GetBooleanValueOrDefault("ingestion.auth.jwt.enabled", false),
+			issuer:          c.GetStringValueOrDefault("ingestion.auth.jwt.issuer", ""),
+			audience:        c.GetStringValueOrDefault("ingestion.auth.jwt.audience", ""),
+			jwks:            jwks,
+			claimId:         c.GetStringValueOrDefault("ingestion.auth.jwt.claims.id", "sub"),
+			claimTenant:     c.GetStringValueOrDefault("ingestion.auth.jwt.claims.tenant", "tenant"),
+			claimEventTypes: c.GetStringValueOrDefault("ingestion.auth.jwt.claims.eventTypes", "event_types"),
+			claimSources:    c.GetStringValueOrDefault("ingestion.auth.jwt.claims.sources", "sources"),
+
+			defaultEventTypes: splitSetting(c.GetStringValueOrDefault("ingestion.auth.jwt.defaultEventTypes", "")),
+			defaultSources:    splitSetting(c.GetStringValueOrDefault("ingestion.auth.jwt.defaultSources", "")),
+		}
+
+		if settings.enabled && (location == "" || settings.issuer == "" || settings.audience == "") {
+			log.Logger.Error("ingestion.auth.jwt.jwks, ingestion.auth.jwt.issuer and ingestion.auth.jwt.audience must be set, bearer tokens will be rejected")
+		}
+
+		authenticator.settings.Store(settings)
+	})
+
+	return authenticator
+}
+
+func (authenticator *jwtAuthenticator) Authenticate(token string) (Principal, error) {
+	settings := authenticator.settings.Load().(jwtSettings)
+	if !settings.enabled {
+		return Principal{}, ErrJwtDisabled
+	}
+
+	if settings.jwks.location == "" || settings.issuer == "" || settings.audience == "" {
+		return Principal{}, errors.New("bearer token authentication is not configured")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.NewParser(jwt.WithValidMethods(jwtMethods)).ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return settings.jwks.key(kid)
+	})
+	if err != nil {
+		return Principal{}, err
+	}
+
+	// The parser only validates the registered claims that are present, short lived tokens must always expire
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return Principal{}, errors.New("the token must have an expiry")
+	}
+	if !claims.VerifyIssuer(settings.issuer, true) {
+		return Principal{}, fmt.Errorf("the token was not issued by %s", settings.issuer)
+	}
+	if !claims.VerifyAudience(settings.audience, true) {
+		return Principal{}, fmt.Errorf("the token is not intended for %s", settings.audience)
+	}
+
+	principal := Principal{
+		Id:         stringClaim(claims, settings.claimId),
+		Tenant:     stringClaim(claims, settings.claimTenant),
+		Method:     MethodJwt,
+		EventTypes: stringsClaim(claims, settings.claimEventTypes),
+		Sources:    stringsClaim(claims, settings.claimSources),
+		Claims:     claims,
+	}
+
+	if principal.Id == "" {
+		return Principal{}, fmt.Errorf("the token does not have a %s claim", settings.claimId)
+	}
+
+	// Empty scopes allow every event, so a token without scopes is only accepted when there are defaults
+	if len(principal.EventTypes) == 0 {
+		principal.EventTypes = settings.defaultEventTypes
+	}
+	if len(principal.Sources) == 0 {
+		principal.Sources = settings.defaultSources
+	}
+	if len(principal.EventTypes) == 0 || len(principal.Sources) == 0 {
+		return Principal{}, fmt.Errorf("the token must have the %s and %s claims", settings.claimEventTypes, settings.claimSources)
+	}
+
+	return principal, nil
+}
+
+func stringClaim(claims jwt.MapClaims, name string) string {
+	value, isString := claims[name].(string)
+	if isString {
+		return value
+	}
+	return ""
+}
+
+// stringsClaim reads a claim that is either an array of strings or a space separated string, eg: scope
+func stringsClaim(claims jwt.MapClaims, name string) []string {
+	result := []string{}
+
+	switch value := claims[name].(type) {
+	case string:
+		result = append(result, strings.Fields(value)...)
+	case []interface{}:
+		for _, item := range value {
+			s, isString := item.(string)
+			if isString && s != "" {
+				result = append(result, s)
+			}
+		}
+	}
+
+	return result
+}
+
+// splitSetting reads a comma separated list from the configuration
+func splitSetting(value string) []string {
+	result := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,84 @@
+package authentication
+
+import (
+	"path"
+	"strings"
+)
+
+const (
+	MethodApiKey string = "apikey"
+)
+
+// Principal is the authenticated identity of a request, the scopes restrict which events it may send
+type Principal struct {
+	Id     string
+	Tenant string
+	Method string
+	// EventTypes are the event type globs that the principal may send, any type when empty
+	EventTypes []string
+	// Sources are the event source prefixes that the principal may send, any source when empty. A prefix only
+	// matches a whole path segment, eg: /orders matches /orders and /orders/eu but not /orders-evil
+	Sources []string
+	// Admin principals can use the administrative endpoints, eg: replaying dead letters
+	Admin bool
+	// Claims are the claims of the token for principals authenticated with a bearer token
+	Claims map[string]interface{}
+}
+
+// Permits returns true when the scopes of the principal allow an event with the type & source
+func (principal Principal) Permits(eventType string, source string) bool {
+	return permitsType(principal.EventTypes, eventType) && permitsSource(principal.Sources, source)
+}
+
+// Input is the representation of the principal given to the ingestion policies as input.principal
+func (principal Principal) Input() map[string]interface{} {
+	return map[string]interface{}{
+		"id":         principal.Id,
+		"tenant":     principal.Tenant,
+		"method":     principal.Method,
+		"eventTypes": toInterfaces(principal.EventTypes),
+		"sources":    toInterfaces(principal.Sources),
+		"admin":      principal.Admin,
+		"claims":     principal.Claims,
+	}
+}
+
+func permitsType(patterns []string, eventType string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		matched, err := path.Match(pattern, eventType)
+		if err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func permitsSource(prefixes []string, source string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range prefixes {
+		if source == prefix {
+			return true
+		}
+
+		// The prefix must end on a path boundary so that a principal can't send from a sibling source
+		if strings.HasPrefix(source, prefix) && (strings.HasSuffix(prefix, "/") || source[len(prefix)] == '/') {
+			return true
+		}
+	}
+	return false
+}
+
+func toInterfaces(values []string) []interface{} {
+	result := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		result = append(result, value)
+	}
+	return result
+}
@@ -0,0 +1,280 @@
+package authentication
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/projectkeas/sdks-service/configuration"
+	log "github.com/projectkeas/sdks-service/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	WEBHOOK_SERVICE_NAME string = "Webhooks"
+
+	MethodWebhook string = "webhook"
+
+	// SchemeGitHub verifies the X-Hub-Signature-256 header sent by GitHub
+	SchemeGitHub string = "github"
+	// SchemeStripe verifies timestamped signatures in the format of the Stripe-Signature header
+	SchemeStripe string = "stripe"
+	// SchemeHmac verifies a signature of the body in a configurable header, algorithm and encoding
+	SchemeHmac string = "hmac"
+)
+
+var ErrInvalidSignature = errors.New("the webhook signature is invalid")
+
+type WebhookProviders interface {
+	// Get returns the provider configured with the name
+	Get(name string) (WebhookProvider, bool)
+}
+
+// WebhookProvider is the configuration of a single provider in ingestion.webhooks.providers
+type WebhookProvider struct {
+	Name   string `json:"-"`
+	Scheme string `json:"scheme"`
+	Secret string `json:"secret"`
+	Tenant string `json:"tenant"`
+	// SignatureHeader is the header that carries the signature, defaulted by the scheme
+	SignatureHeader string `json:"signatureHeader"`
+	// Algorithm is the hash used by the hmac scheme: sha1, sha256 or sha512 (default: sha256)
+	Algorithm string `json:"algorithm"`
+	// SignaturePrefix is removed from the signature before it is compared by the hmac scheme, eg: sha256=
+	SignaturePrefix string `json:"signaturePrefix"`
+	// Encoding is the encoding of the signature used by the hmac scheme: hex or base64 (default: hex)
+	Encoding string `json:"encoding"`
+	// Tolerance is the maximum age of a timestamped signature used by the stripe scheme (default: 5m)
+	Tolerance string `json:"tolerance"`
+	// EventHeader & EventField are the header or top level field of a JSON body that contain the event type
+	EventHeader string `json:"eventHeader"`
+	EventField  string `json:"eventField"`
+	// DeliveryHeader & IdField are the header or top level field of a JSON body that contain the event id
+	DeliveryHeader string `json:"deliveryHeader"`
+	IdField        string `json:"idField"`
+	// TypePrefix is prepended to the event type, eg: com.github.
+	TypePrefix string `json:"typePrefix"`
+	// Source is the source of the events (default: /webhooks/<name>)
+	Source     string   `json:"source"`
+	EventTypes []string `json:"eventTypes"`
+	Enabled    *bool    `json:"enabled"`
+
+	tolerance time.Duration
+}
+
+type webhookProviders struct {
+	providers atomic.Value // map[string]WebhookProvider
+}
+
+func NewWebhookProviders(config *configuration.ConfigurationRoot) WebhookProviders {
+	store := &webhookProviders{}
+	store.providers.Store(map[string]WebhookProvider{})
+
+	config.RegisterChangeNotificationHandler(func(c configuration.ConfigurationRoot) {
+		value := c.GetStringValueOrDefault("ingestion.webhooks.providers", "")
+		if value == "" {
+			store.providers.Store(map[string]WebhookProvider{})
+			return
+		}
+
+		configured := map[string]WebhookProvider{}
+		err := json.Unmarshal([]byte(value), &configured)
+		if err != nil {
+			log.Logger.Error("unable to parse ingestion.webhooks.providers, keeping the previous providers", zap.Error(err))
+			return
+		}
+
+		providers := map[string]WebhookProvider{}
+		for name, provider := range configured {
+			provider.Name = name
+			err := provider.applyDefaults()
+			if err != nil {
+				log.Logger.Error("ignoring invalid webhook provider", zap.String("provider", name), zap.Error(err))
+				continue
+			}
+			providers[name] = provider
+		}
+
+		store.providers.Store(providers)
+	})
+
+	return store
+}
+
+func (store *webhookProviders) Get(name string) (WebhookProvider, bool) {
+	provider, found := store.providers.Load().(map[string]WebhookProvider)[name]
+	if !found || (provider.Enabled != nil && !*provider.Enabled) {
+		return WebhookProvider{}, false
+	}
+	return provider, true
+}
+
+func (provider *WebhookProvider) applyDefaults() error {
+	if provider.Secret == "" {
+		return errors.New("a secret is required")
+	}
+
+	switch provider.Scheme {
+	case SchemeGitHub:
+		provider.SignatureHeader = valueOrDefault(provider.SignatureHeader, "X-Hub-Signature-256")
+		provider.Algorithm = "sha256"
+		provider.SignaturePrefix = "sha256="
+		provider.Encoding = "hex"
+		provider.EventHeader = valueOrDefault(provider.EventHeader, "X-GitHub-Event")
+		provider.DeliveryHeader = valueOrDefault(provider.DeliveryHeader, "X-GitHub-Delivery")
+	case SchemeStripe:
+		provider.SignatureHeader = valueOrDefault(provider.SignatureHeader, "Stripe-Signature")
+		provider.EventField = valueOrDefault(provider.EventField, "type")
+		provider.IdField = valueOrDefault(provider.IdField, "id")
+
+		tolerance, err := time.ParseDuration(valueOrDefault(provider.Tolerance, "5m"))
+		if err != nil {
+			return fmt.Errorf("unable to parse the tolerance: %w", err)
+		}
+		provider.tolerance = tolerance
+	case SchemeHmac:
+		if provider.SignatureHeader == "" {
+			return errors.New("a signatureHeader is required")
+		}
+		provider.Algorithm = valueOrDefault(provider.Algorithm, "sha256")
+		provider.Encoding = valueOrDefault(provider.Encoding, "hex")
+		if newHash(provider.Algorithm) == nil {
+			return fmt.Errorf("the algorithm %s is not supported", provider.Algorithm)
+		}
+		if provider.Encoding != "hex" && provider.Encoding != "base64" {
+			return fmt.Errorf("the encoding %s is not supported", provider.Encoding)
+		}
+	default:
+		return fmt.Errorf("the scheme '%s' is not supported", provider.Scheme)
+	}
+
+	if provider.EventHeader == "" && provider.EventField == "" {
+		return errors.New("an eventHeader or eventField is required")
+	}
+
+	provider.Source = valueOrDefault(provider.Source, "/webhooks/"+provider.Name)
+	return nil
+}
+
+// Verify checks the signature of the request body, header returns the value of a request header
+func (provider WebhookProvider) Verify(header func(name string) string, body []byte) error {
+	signature := header(provider.SignatureHeader)
+	if signature == "" {
+		return fmt.Errorf("the %s header is missing", provider.SignatureHeader)
+	}
+
+	if provider.Scheme == SchemeStripe {
+		return provider.verifyTimestamped(signature, body, time.Now())
+	}
+
+	if !strings.HasPrefix(signature, provider.SignaturePrefix) {
+		return ErrInvalidSignature
+	}
+
+	var expected []byte
+	var err error
+	if provider.Encoding == "base64" {
+		expected, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(signature, provider.SignaturePrefix))
+	} else {
+		expected, err = hex.DecodeString(strings.TrimPrefix(signature, provider.SignaturePrefix))
+	}
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(newHash(provider.Algorithm), []byte(provider.Secret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// verifyTimestamped checks a signature in the format t=<unix time>,v1=<hex signature> where the signed payload
+// is <unix time>.<body>, the timestamp must be within the tolerance to prevent replays
+func (provider WebhookProvider) verifyTimestamped(signature string, body []byte, now time.Time) error {
+	timestamp := ""
+	signatures := [][]byte{}
+	for _, part := range strings.Split(signature, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			decoded, err := hex.DecodeString(value)
+			if err == nil {
+				signatures = append(signatures, decoded)
+			}
+		}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > provider.tolerance || age < -provider.tolerance {
+		return fmt.Errorf("the webhook signature timestamp is outside of the tolerance of %s", provider.tolerance)
+	}
+
+	mac := hmac.New(sha256.New, []byte(provider.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	computed := mac.Sum(nil)
+
+	// Multiple signatures are sent whilst the secret is being rolled
+	for _, expected := range signatures {
+		if hmac.Equal(computed, expected) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+// Principal is the identity of the provider once the signature has been verified
+func (provider WebhookProvider) Principal() Principal {
+	return Principal{
+		Id:         provider.Name,
+		Tenant:     provider.Tenant,
+		Method:     MethodWebhook,
+		EventTypes: provider.EventTypes,
+		Sources:    []string{provider.Source},
+	}
+}
+
+func newHash(algorithm string) func() hash.Hash {
+	switch algorithm {
+	case "sha1":
+		return sha1.New
+	case "sha256":
+		return sha256.New
+	case "sha512":
+		return sha512.New
+	}
+	return nil
+}
+
+func valueOrDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,38 @@
+package contentTypes
+
+import (
+	"mime"
+	"strings"
+)
+
+const (
+	ApplicationJSON = "application/json"
+)
+
+// MediaType returns the lower-cased media type without any parameters, eg: charset
+func MediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+
+	return mediaType
+}
+
+// IsJSON returns true for JSON based content types. An empty content type is treated as JSON
+// as per the CloudEvents JSON format
+func IsJSON(contentType string) bool {
+	mediaType := MediaType(contentType)
+	return mediaType == "" ||
+		mediaType == ApplicationJSON ||
+		mediaType == "text/json" ||
+		strings.HasSuffix(mediaType, "+json")
+}
+
+// IsText returns true for content types that are human readable such as text/plain, text/csv or XML
+func IsText(contentType string) bool {
+	mediaType := MediaType(contentType)
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/xml" ||
+		strings.HasSuffix(mediaType, "+xml")
+}
@@ -0,0 +1,146 @@
+package deadLetters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+	log "github.com/projectkeas/sdks-service/logger"
+	"go.uber.org/zap"
+)
+
+const readTimeout = 2 * time.Second
+
+// ReadOptions restricts which dead letters are read from the stream
+type ReadOptions struct {
+	From time.Time
+	To   time.Time
+}
+
+// Record is a dead letter read back from the stream along with the original event
+type Record struct {
+	DeadLetter
+	Event     cloudevents.Event
+	Sequence  uint64
+	Timestamp time.Time
+
+	remove func() error
+}
+
+// Remove deletes the dead letter from the stream, eg: once it has been replayed so that it can't be replayed twice.
+// It can only be used whilst the stream is being read
+func (record Record) Remove() error {
+	if record.remove == nil {
+		return errors.New("the dead letter can only be removed whilst reading the stream")
+	}
+	return record.remove()
+}
+
+type envelopeData struct {
+	DeadLetter
+	Event json.RawMessage `json:"event"`
+}
+
+// Read iterates the dead letter stream from the oldest message (or From) until the end of the
+// stream (or To) has been reached. Returning false from the handler stops reading
+func (service *deadLetterExecutionService) Read(options ReadOptions, handler func(record Record) bool) error {
+	service.mutex.RLock()
+	uri, streamName, subject := service.natsUri, service.streamName, service.subject
+	service.mutex.RUnlock()
+
+	nc, err := nats.Connect(uri)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return err
+	}
+
+	subscriptionOptions := []nats.SubOpt{nats.BindStream(streamName), nats.OrderedConsumer()}
+	if options.From.IsZero() {
+		subscriptionOptions = append(subscriptionOptions, nats.DeliverAll())
+	} else {
+		subscriptionOptions = append(subscriptionOptions, nats.StartTime(options.From))
+	}
+
+	sub, err := js.SubscribeSync(subject, subscriptionOptions...)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		msg, err := sub.NextMsg(readTimeout)
+		if errors.Is(err, nats.ErrTimeout) {
+			// nothing left in the stream
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		metadata, err := msg.Metadata()
+		if err != nil {
+			return err
+		}
+
+		if !options.To.IsZero() && metadata.Timestamp.After(options.To) {
+			return nil
+		}
+
+		record, err := readRecord(msg.Data)
+		if err != nil {
+			log.Logger.Warn("Unable to read dead letter", zap.Uint64("sequence", metadata.Sequence.Stream), zap.Error(err))
+		} else {
+			record.Sequence = metadata.Sequence.Stream
+			record.Timestamp = metadata.Timestamp
+
+			sequence := record.Sequence
+			record.remove = func() error {
+				return js.DeleteMsg(streamName, sequence)
+			}
+
+			if !handler(record) {
+				return nil
+			}
+		}
+
+		if metadata.NumPending == 0 {
+			return nil
+		}
+	}
+}
+
+func readRecord(data []byte) (Record, error) {
+	envelope := cloudevents.NewEvent()
+	err := json.Unmarshal(data, &envelope)
+	if err != nil {
+		return Record{}, err
+	}
+
+	if envelope.Type() != DeadLetterEventType {
+		return Record{}, fmt.Errorf("unexpected event type: %s", envelope.Type())
+	}
+
+	content := envelopeData{}
+	err = json.Unmarshal(envelope.Data(), &content)
+	if err != nil {
+		return Record{}, err
+	}
+
+	event := cloudevents.NewEvent()
+	err = json.Unmarshal(content.Event, &event)
+	if err != nil {
+		return Record{}, err
+	}
+
+	return Record{
+		DeadLetter: content.DeadLetter,
+		Event:      event,
+	}, nil
+}
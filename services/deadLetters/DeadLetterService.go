@@ -0,0 +1,129 @@
+package deadLetters
+
+import (
+	"fmt"
+	"sync"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+	"github.com/projectkeas/ingestion/services/eventPublisher"
+	"github.com/projectkeas/sdks-service/configuration"
+	log "github.com/projectkeas/sdks-service/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	SERVICE_NAME string = "DeadLetters"
+
+	DeadLetterEventType string = "io.keas.ingestion.deadletter"
+	EnvelopeSource      string = "/ingestion"
+)
+
+// DeadLetter describes why an event was refused by the ingestion pipeline
+type DeadLetter struct {
+	Reason  string      `json:"reason"`
+	Message string      `json:"message,omitempty"`
+	Errors  interface{} `json:"errors,omitempty"`
+	Policy  string      `json:"policy,omitempty"`
+	// Principal sent the event, it is nil for dead letters written before the principal was recorded
+	Principal *Principal `json:"principal,omitempty"`
+}
+
+// Principal is the identity & scopes of the principal that sent a dead lettered event so that the same
+// scopes and ingestion policies apply when it is replayed
+type Principal struct {
+	Id         string   `json:"id"`
+	Tenant     string   `json:"tenant,omitempty"`
+	Method     string   `json:"method,omitempty"`
+	EventTypes []string `json:"eventTypes,omitempty"`
+	Sources    []string `json:"sources,omitempty"`
+}
+
+type DeadLetterService interface {
+	Publish(event cloudevents.Event, letter DeadLetter) bool
+	Read(options ReadOptions, handler func(record Record) bool) error
+	// Subject is the subject that dead letters are published to
+	Subject() string
+}
+
+type deadLetterExecutionService struct {
+	publisher  eventPublisher.EventPublisherService
+	enabled    bool
+	natsUri    string
+	streamName string
+	subject    string
+	mutex      *sync.RWMutex
+}
+
+func New(config *configuration.ConfigurationRoot, publisher eventPublisher.EventPublisherService) DeadLetterService {
+	service := &deadLetterExecutionService{
+		publisher: publisher,
+		mutex:     &sync.RWMutex{},
+	}
+
+	config.RegisterChangeNotificationHandler(func(c configuration.ConfigurationRoot) {
+		service.mutex.Lock()
+		defer service.mutex.Unlock()
+
+		service.enabled = c.GetBooleanValueOrDefault("ingestion.deadLetter.enabled", false)
+		service.streamName = c.GetStringValueOrDefault("ingestion.deadLetter.stream", "ingestion")
+		service.subject = c.GetStringValueOrDefault("ingestion.deadLetter.subject", "ingestion.deadletter")
+		service.natsUri = fmt.Sprintf("%s:%s",
+			c.GetStringValueOrDefault("nats.address", "nats-cluster.svc.cluster.local"),
+			c.GetStringValueOrDefault("nats.port", "4222"))
+	})
+
+	return service
+}
+
+// Publish sends the original event and the reason it was refused to the dead letter stream. Returns
+// false when dead lettering is disabled or the event could not be published
+func (service *deadLetterExecutionService) Publish(event cloudevents.Event, letter DeadLetter) bool {
+	service.mutex.RLock()
+	enabled, streamName, subject := service.enabled, service.streamName, service.subject
+	service.mutex.RUnlock()
+
+	if !enabled {
+		return false
+	}
+
+	envelope, err := NewEnvelope(DeadLetterEventType, event, letter)
+	if err != nil {
+		log.Logger.Error("Unable to create dead letter event", zap.Error(err))
+		return false
+	}
+
+	if !service.publisher.PublishToSubject(envelope, streamName, subject) {
+		log.Logger.Error("Unable to publish dead letter event", zap.String("reason", letter.Reason), zap.String("id", event.ID()))
+		return false
+	}
+
+	return true
+}
+
+func (service *deadLetterExecutionService) Subject() string {
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+	return service.subject
+}
+
+// NewEnvelope wraps the original event along with the reason it was refused so that it
+// can be audited and replayed at a later date
+func NewEnvelope(envelopeType string, original cloudevents.Event, letter DeadLetter) (cloudevents.Event, error) {
+	envelope := cloudevents.NewEvent()
+	envelope.SetID(uuid.NewString())
+	envelope.SetType(envelopeType)
+	envelope.SetSource(EnvelopeSource)
+	envelope.SetSubject(original.Type())
+
+	err := envelope.SetData(cloudevents.ApplicationJSON, map[string]interface{}{
+		"reason":    letter.Reason,
+		"message":   letter.Message,
+		"errors":    letter.Errors,
+		"policy":    letter.Policy,
+		"principal": letter.Principal,
+		"event":     original,
+	})
+
+	return envelope, err
+}
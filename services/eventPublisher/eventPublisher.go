@@ -22,6 +22,7 @@ const (
 
 type EventPublisherService interface {
 	Publish(event cloudevents.Event) bool
+	PublishToSubject(event cloudevents.Event, streamName string, subject string) bool
 }
 
 type eventPublisherExecutionService struct {
@@ -47,6 +48,12 @@ func New(config *configuration.ConfigurationRoot) EventPublisherService {
 }
 
 func (ep *eventPublisherExecutionService) Publish(event cloudevents.Event) bool {
+	streamName, subject := getStreamConfig(event.Type())
+	return ep.PublishToSubject(event, streamName, subject)
+}
+
+// PublishToSubject sends the event to an explicit stream & subject rather than one derived from the event type
+func (ep *eventPublisherExecutionService) PublishToSubject(event cloudevents.Event, streamName string, subject string) bool {
 	err := event.Validate()
 	if err != nil {
 		log.Logger.Error("Unable to validate outbound CloudEvent", zap.Error(err))
@@ -60,7 +67,6 @@ func (ep *eventPublisherExecutionService) Publish(event cloudevents.Event) bool
 		ep.natsClientCache = map[string]cloudevents.Client{}
 	}
 
-	streamName, subject := getStreamConfig(event.Type())
 	client, found := ep.natsClientCache[subject]
 	if !found {
 		address := ep.config.GetStringValueOrDefault("nats.address", "nats-cluster.svc.cluster.local")
@@ -132,6 +138,28 @@ func getStreamConfig(input string) (string, string) {
 	return input, input
 }
 
+// StreamForSubject returns the name of the stream for an explicit subject, ie: the first token
+func StreamForSubject(subject string) string {
+	return strings.Split(subject, ".")[0]
+}
+
+// ValidateSubject checks that an explicit subject can be published to, it must have a stream and at least one
+// more token and can't contain wildcards or whitespace
+func ValidateSubject(subject string) error {
+	tokens := strings.Split(subject, ".")
+	if len(tokens) < 2 {
+		return fmt.Errorf("the subject %s must have a stream and at least one more token", subject)
+	}
+
+	for _, token := range tokens {
+		if token == "" || token == "*" || token == ">" || strings.ContainsAny(token, " \t\r\n") {
+			return fmt.Errorf("the subject %s contains an invalid token: '%s'", subject, token)
+		}
+	}
+
+	return nil
+}
+
 func getSubjectWildcard(input string) string {
 	sections := strings.Split(input, ".")
 	joined := strings.Join(sections[0:len(sections)-1], ".")
@@ -0,0 +1,117 @@
+package eventTypes
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/linkedin/goavro/v2"
+	"github.com/projectkeas/ingestion/services/contentTypes"
+	jsonSchema "github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+const (
+	avroKeywordLocation = "/avro"
+
+	// confluentMagicByte prefixes payloads written with the Confluent schema registry wire format
+	// and is followed by a 4 byte big endian schema id
+	confluentMagicByte  byte = 0
+	confluentHeaderSize int  = 5
+
+	// WireFormatExtension declares the wire format of avro data, plain avro records can also start with a 0 byte
+	// so the Confluent wire format is only detected for EventTypes with a schema id or when this is set
+	WireFormatExtension string = "wireformat"
+	WireFormatConfluent string = "confluent"
+)
+
+var avroContentTypes = map[string]bool{
+	"application/avro":                   true,
+	"avro/binary":                        true,
+	"application/vnd.apache.avro+binary": true,
+}
+
+type avroValidator struct {
+	codec    *goavro.Codec
+	schemaId int
+}
+
+func newAvroValidator(schema string, schemaId int) (schemaValidator, error) {
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return avroValidator{
+		codec:    codec,
+		schemaId: schemaId,
+	}, nil
+}
+
+func (validator avroValidator) Validate(event cloudevents.Event, data interface{}) error {
+	contentType := contentTypes.MediaType(event.DataContentType())
+
+	var err error
+	var remaining []byte
+	if IsAvroContentType(contentType) {
+		payload := event.Data()
+		if validator.schemaId != 0 || UsesConfluentWireFormat(event) {
+			schemaId, hasHeader := ConfluentSchemaId(payload)
+			if !hasHeader {
+				return newAvroValidationError("the payload is not in the Confluent wire format")
+			}
+			if validator.schemaId != 0 && schemaId != validator.schemaId {
+				return newAvroValidationError(fmt.Sprintf("schema id %d does not match the registered schema id %d", schemaId, validator.schemaId))
+			}
+			payload = payload[confluentHeaderSize:]
+		}
+
+		_, remaining, err = validator.codec.NativeFromBinary(payload)
+	} else if contentTypes.IsJSON(contentType) {
+		_, remaining, err = validator.codec.NativeFromTextual(event.Data())
+	} else {
+		err = fmt.Errorf("content type '%s' cannot be validated against an avro schema", contentType)
+	}
+
+	if err != nil {
+		return newAvroValidationError(err.Error())
+	}
+
+	if len(remaining) > 0 {
+		return newAvroValidationError(fmt.Sprintf("%d unexpected bytes after the end of the record", len(remaining)))
+	}
+
+	return nil
+}
+
+// IsAvroContentType returns true for the content types that carry avro binary encoded data
+func IsAvroContentType(contentType string) bool {
+	return avroContentTypes[contentTypes.MediaType(contentType)]
+}
+
+// UsesConfluentWireFormat returns true when the event declares that its data is in the Confluent wire format
+func UsesConfluentWireFormat(event cloudevents.Event) bool {
+	value, err := event.Context.GetExtension(WireFormatExtension)
+	return err == nil && fmt.Sprint(value) == WireFormatConfluent
+}
+
+// ConfluentSchemaId reads the schema id from a payload using the Confluent schema registry wire format
+func ConfluentSchemaId(payload []byte) (int, bool) {
+	if len(payload) < confluentHeaderSize || payload[0] != confluentMagicByte {
+		return 0, false
+	}
+
+	return int(binary.BigEndian.Uint32(payload[1:confluentHeaderSize])), true
+}
+
+func newAvroValidationError(message string) *jsonSchema.ValidationError {
+	return &jsonSchema.ValidationError{
+		KeywordLocation: avroKeywordLocation,
+		Message:         "payload does not match the avro schema",
+		Causes: []*jsonSchema.ValidationError{
+			{
+				KeywordLocation: avroKeywordLocation,
+				Message:         message,
+			},
+		},
+	}
+}
@@ -1,53 +1,173 @@
 package eventTypes
 
 import (
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/projectkeas/ingestion/services"
+	"github.com/projectkeas/ingestion/services/resourceStatus"
 	"go.uber.org/zap"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	types "github.com/projectkeas/crds/pkg/apis/keas.io/v1alpha1"
+	"github.com/projectkeas/sdks-service/configuration"
 	log "github.com/projectkeas/sdks-service/logger"
-	jsonSchema "github.com/santhosh-tekuri/jsonschema/v5"
 )
 
 const (
 	SERVICE_NAME string = "EventTypes"
 )
 
+var (
+	// ErrSchemaNotResolved is returned in strict mode when an event doesn't resolve to any registered EventType
+	ErrSchemaNotResolved = errors.New("no schema could be resolved for the event")
+	// ErrSchemaNotFound is returned when the dataschema of an event isn't registered as an EventType
+	ErrSchemaNotFound = errors.New("no matching schema found")
+)
+
 type EventTypeService interface {
-	Validate(event cloudevents.Event, data map[string]interface{}) error
+	Validate(event cloudevents.Event, data interface{}) error
+	ResolveDataSchema(event cloudevents.Event) (string, bool)
+	Describe(event cloudevents.Event) (EventTypeDescription, bool)
 }
 
+// eventTypesExecutionService validates events against an immutable snapshot of the EventTypes which is
+// swapped atomically when an EventType changes, so requests never take a lock or observe a partial update
 type eventTypesExecutionService struct {
-	eventTypes map[string]validatableEventType
+	snapshot atomic.Value // eventTypeSnapshot
+	settings atomic.Value // eventTypeSettings
+	mutex    sync.Mutex   // serialises updates to the snapshot
+	status   resourceStatus.StatusReporter
+}
+
+// eventTypeSnapshot must not be modified once it has been stored, updates create a new snapshot
+type eventTypeSnapshot struct {
+	eventTypes   map[string]validatableEventType
+	schemaIds    map[int]string
+	typeVersions map[string]string
+}
+
+type eventTypeSettings struct {
+	strict           bool
+	versionExtension string
+}
+
+func (service *eventTypesExecutionService) load() eventTypeSnapshot {
+	return service.snapshot.Load().(eventTypeSnapshot)
 }
 
-func (service eventTypesExecutionService) Validate(event cloudevents.Event, data map[string]interface{}) error {
+// update applies a change to a copy of the current EventTypes and swaps in the new snapshot
+func (service *eventTypesExecutionService) update(change func(snapshot eventTypeSnapshot) bool) bool {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	current := service.load()
+	next := eventTypeSnapshot{
+		eventTypes:   make(map[string]validatableEventType, len(current.eventTypes)+1),
+		schemaIds:    make(map[int]string, len(current.schemaIds)+1),
+		typeVersions: make(map[string]string, len(current.typeVersions)+1),
+	}
+	for key, value := range current.eventTypes {
+		next.eventTypes[key] = value
+	}
+	for key, value := range current.schemaIds {
+		next.schemaIds[key] = value
+	}
+	for key, value := range current.typeVersions {
+		next.typeVersions[key] = value
+	}
+
+	if !change(next) {
+		return false
+	}
+
+	service.snapshot.Store(next)
+	return true
+}
+
+// ResolveDataSchema finds the schema uri for events that don't declare a dataschema. Avro payloads that declare
+// the Confluent wire format are resolved using the schema id in the payload, otherwise the event type and
+// the optional version extension are matched against the EventType annotations
+func (service *eventTypesExecutionService) ResolveDataSchema(event cloudevents.Event) (string, bool) {
+	snapshot := service.load()
+
+	if IsAvroContentType(event.DataContentType()) && UsesConfluentWireFormat(event) {
+		schemaId, found := ConfluentSchemaId(event.Data())
+		if found {
+			schemaUri, found := snapshot.schemaIds[schemaId]
+			return schemaUri, found
+		}
+	}
+
+	version := ""
+	versionExtension := service.settings.Load().(eventTypeSettings).versionExtension
+	if versionExtension != "" {
+		value, err := event.Context.GetExtension(versionExtension)
+		if err == nil {
+			version = fmt.Sprint(value)
+		}
+	}
+
+	schemaUri, found := snapshot.typeVersions[formatTypeVersionKey(event.Type(), version)]
+	if !found && version != "" {
+		// Versions without their own EventType fall back to the unversioned EventType of the type
+		schemaUri, found = snapshot.typeVersions[formatTypeVersionKey(event.Type(), "")]
+	}
+	return schemaUri, found
+}
+
+// Describe returns the EventType registered for the dataschema of the event
+func (service *eventTypesExecutionService) Describe(event cloudevents.Event) (EventTypeDescription, bool) {
+	vt, found := service.load().eventTypes[event.DataSchema()]
+	if !found {
+		return EventTypeDescription{}, false
+	}
+	return vt.describe(), true
+}
+
+func (service *eventTypesExecutionService) Validate(event cloudevents.Event, data interface{}) error {
 
 	key := event.DataSchema()
 
 	if key == "" {
-		// TODO :: use server context to decide whether to allow schemaless cloudevents
+		if service.settings.Load().(eventTypeSettings).strict {
+			return ErrSchemaNotResolved
+		}
 		return nil
 	}
 
-	vt, found := service.eventTypes[key]
+	vt, found := service.load().eventTypes[key]
 	if found {
-		return vt.Validate(data)
+		return vt.Validate(event, data)
 	}
 
-	return fmt.Errorf("no matching schema found for: %s", key)
+	return fmt.Errorf("%w for: %s", ErrSchemaNotFound, key)
 }
 
-func New() EventTypeService {
+func New(config *configuration.ConfigurationRoot, status resourceStatus.StatusReporter) EventTypeService {
 
 	informerFactory := services.GetInformer()
-	service := &eventTypesExecutionService{}
+	service := &eventTypesExecutionService{
+		status: status,
+	}
+	service.snapshot.Store(eventTypeSnapshot{
+		eventTypes:   map[string]validatableEventType{},
+		schemaIds:    map[int]string{},
+		typeVersions: map[string]string{},
+	})
+	service.settings.Store(eventTypeSettings{})
+
+	config.RegisterChangeNotificationHandler(func(c configuration.ConfigurationRoot) {
+		service.settings.Store(eventTypeSettings{
+			strict:           c.GetBooleanValueOrDefault("ingestion.schema.strict", false),
+			versionExtension: c.GetStringValueOrDefault("ingestion.schema.versionExtension", "dataversion"),
+		})
+	})
 
 	eventTypesFactory := informerFactory.Keas().V1alpha1().EventTypes()
 	eventTypesFactory.Informer().AddEventHandlerWithResyncPeriod(cache.ResourceEventHandlerFuncs{
@@ -98,39 +218,71 @@ func onUpdatedEventType(service *eventTypesExecutionService) func(oldEventTypeIn
 }
 
 func addOrUpdateEventType(service *eventTypesExecutionService, eventType *types.EventType) bool {
-	if service.eventTypes == nil {
-		service.eventTypes = map[string]validatableEventType{}
-	}
-
-	et, found := service.eventTypes[eventType.Spec.SchemaUri]
-	if (found) && et.version == eventType.ResourceVersion {
+	et, found := service.load().eventTypes[eventType.Spec.SchemaUri]
+	if (found) && et.version == services.Revision(eventType) {
 		return false
 	}
 
-	schema, err := jsonSchema.CompileString("schema.json", eventType.Spec.Schema)
+	vt, err := compileEventType(eventType)
 	if err != nil {
-		log.Logger.Error("Cannot parse json schema. Not adding schema to collection", zap.Any("eventType", map[string]string{
+		log.Logger.Error("Cannot parse schema. Not adding schema to collection", zap.Any("eventType", map[string]string{
 			"name":      eventType.Name,
 			"namespace": eventType.Namespace,
 			"schemaUri": eventType.Spec.SchemaUri,
 		}), zap.Error(err))
+		service.status.CompileFailed(resourceStatus.KindEventType, eventType, err)
 		return false
 	}
 
-	service.eventTypes[eventType.Spec.SchemaUri] = validatableEventType{
-		schema:    *schema,
-		schemaUri: eventType.Spec.SchemaUri,
-		version:   eventType.ResourceVersion,
+	// A schema id can only resolve to a single EventType
+	if vt.schemaId != 0 {
+		existing, found := service.load().schemaIds[vt.schemaId]
+		if found && existing != vt.schemaUri {
+			err := fmt.Errorf("the %s annotation %d is already used by the EventType with the schemaUri %s", SchemaIdAnnotation, vt.schemaId, existing)
+			log.Logger.Error("Duplicate schema id. Not adding schema to collection", zap.Any("eventType", map[string]string{
+				"name":      eventType.Name,
+				"namespace": eventType.Namespace,
+				"schemaUri": eventType.Spec.SchemaUri,
+			}), zap.Error(err))
+			service.status.CompileFailed(resourceStatus.KindEventType, eventType, err)
+			return false
+		}
 	}
 
-	return true
+	service.status.Compiled(resourceStatus.KindEventType, eventType)
+	return service.update(func(snapshot eventTypeSnapshot) bool {
+		et, found := snapshot.eventTypes[eventType.Spec.SchemaUri]
+		if (found) && et.version == services.Revision(eventType) {
+			return false
+		}
+
+		if found {
+			removeEventType(snapshot, et)
+		}
+		snapshot.eventTypes[eventType.Spec.SchemaUri] = vt
+		if existing, found := snapshot.schemaIds[vt.schemaId]; vt.schemaId != 0 && (!found || existing == vt.schemaUri) {
+			snapshot.schemaIds[vt.schemaId] = vt.schemaUri
+		}
+		if vt.eventType != "" {
+			snapshot.typeVersions[formatTypeVersionKey(vt.eventType, vt.eventVersion)] = vt.schemaUri
+		}
+
+		return true
+	})
 }
 
 func onDeletedEventType(service *eventTypesExecutionService) func(eventTypeInterface interface{}) {
 	return func(policyInterface interface{}) {
 		eventType, successfulCast := policyInterface.(*types.EventType)
 		if successfulCast {
-			delete(service.eventTypes, eventType.Spec.SchemaUri)
+			service.update(func(snapshot eventTypeSnapshot) bool {
+				et, found := snapshot.eventTypes[eventType.Spec.SchemaUri]
+				if found {
+					removeEventType(snapshot, et)
+				}
+				return found
+			})
+			service.status.Forget(resourceStatus.KindEventType, eventType.Namespace, eventType.Name)
 
 			log.Logger.Info("deleted event type", zap.Any("eventType", map[string]string{
 				"name":      eventType.Name,
@@ -142,3 +294,20 @@ func onDeletedEventType(service *eventTypesExecutionService) func(eventTypeInter
 		}
 	}
 }
+
+func removeEventType(snapshot eventTypeSnapshot, et validatableEventType) {
+	delete(snapshot.eventTypes, et.schemaUri)
+
+	if et.schemaId != 0 && snapshot.schemaIds[et.schemaId] == et.schemaUri {
+		delete(snapshot.schemaIds, et.schemaId)
+	}
+
+	key := formatTypeVersionKey(et.eventType, et.eventVersion)
+	if et.eventType != "" && snapshot.typeVersions[key] == et.schemaUri {
+		delete(snapshot.typeVersions, key)
+	}
+}
+
+func formatTypeVersionKey(eventType string, version string) string {
+	return fmt.Sprintf("%s|%s", eventType, version)
+}
@@ -0,0 +1,41 @@
+package eventTypes
+
+import (
+	"fmt"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/projectkeas/ingestion/services/contentTypes"
+	jsonSchema "github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+type jsonSchemaValidator struct {
+	schema *jsonSchema.Schema
+}
+
+func newJsonSchemaValidator(schema string) (schemaValidator, error) {
+	compiled, err := jsonSchema.CompileString("schema.json", schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return jsonSchemaValidator{
+		schema: compiled,
+	}, nil
+}
+
+func (validator jsonSchemaValidator) Validate(event cloudevents.Event, data interface{}) error {
+	// JSON schemas can only describe JSON payloads, any other content type would bypass the schema
+	contentType := contentTypes.MediaType(event.DataContentType())
+	if !contentTypes.IsJSON(contentType) {
+		return &jsonSchema.ValidationError{
+			Message: "payload does not match the json schema",
+			Causes: []*jsonSchema.ValidationError{
+				{
+					Message: fmt.Sprintf("content type '%s' cannot be validated against a json schema", contentType),
+				},
+			},
+		}
+	}
+
+	return validator.schema.Validate(data)
+}
@@ -0,0 +1,170 @@
+package eventTypes
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/projectkeas/ingestion/services/contentTypes"
+	jsonSchema "github.com/santhosh-tekuri/jsonschema/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const protobufKeywordLocation = "/protobuf"
+
+var protobufContentTypes = map[string]bool{
+	"application/protobuf":            true,
+	"application/x-protobuf":          true,
+	"application/vnd.google.protobuf": true,
+}
+
+type protobufValidator struct {
+	message protoreflect.MessageDescriptor
+}
+
+// newProtobufValidator compiles a base64 encoded FileDescriptorSet (protoc --include_imports --descriptor_set_out)
+// and locates the message that payloads are decoded against
+func newProtobufValidator(schema string, messageName string) (schemaValidator, error) {
+	if messageName == "" {
+		return nil, fmt.Errorf("the %s annotation is required for protobuf schemas", ProtobufMessageAnnotation)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(schema))
+	if err != nil {
+		return nil, fmt.Errorf("protobuf schemas must be a base64 encoded descriptor set: %w", err)
+	}
+
+	descriptorSet := &descriptorpb.FileDescriptorSet{}
+	err = proto.Unmarshal(raw, descriptorSet)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read protobuf descriptor set: %w", err)
+	}
+
+	files, err := protodesc.NewFiles(descriptorSet)
+	if err != nil {
+		return nil, fmt.Errorf("unable to link protobuf descriptor set: %w", err)
+	}
+
+	descriptor, err := files.FindDescriptorByName(protoreflect.FullName(messageName))
+	if err != nil {
+		return nil, fmt.Errorf("unable to find protobuf message %s: %w", messageName, err)
+	}
+
+	message, isMessage := descriptor.(protoreflect.MessageDescriptor)
+	if !isMessage {
+		return nil, fmt.Errorf("%s is not a protobuf message", messageName)
+	}
+
+	return protobufValidator{
+		message: message,
+	}, nil
+}
+
+func (validator protobufValidator) Validate(event cloudevents.Event, data interface{}) error {
+	message := dynamicpb.NewMessage(validator.message)
+	contentType := contentTypes.MediaType(event.DataContentType())
+
+	var err error
+	if protobufContentTypes[contentType] {
+		err = proto.UnmarshalOptions{AllowPartial: true}.Unmarshal(event.Data(), message)
+	} else if contentTypes.IsJSON(contentType) {
+		err = protojson.UnmarshalOptions{AllowPartial: true}.Unmarshal(event.Data(), message)
+	} else {
+		err = fmt.Errorf("content type '%s' cannot be validated against a protobuf schema", contentType)
+	}
+
+	if err != nil {
+		return newProtobufValidationError(protobufError("", err.Error()))
+	}
+
+	causes := collectProtobufErrors(message, "")
+	if len(causes) > 0 {
+		return newProtobufValidationError(causes...)
+	}
+
+	return nil
+}
+
+// collectProtobufErrors walks the decoded message reporting missing required fields and fields
+// that are not part of the message definition
+func collectProtobufErrors(message protoreflect.Message, path string) []*jsonSchema.ValidationError {
+	causes := []*jsonSchema.ValidationError{}
+
+	fields := message.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		location := path + "/" + string(field.Name())
+
+		if field.Cardinality() == protoreflect.Required && !message.Has(field) {
+			causes = append(causes, protobufError(location, "missing required field"))
+			continue
+		}
+
+		if !message.Has(field) {
+			continue
+		}
+
+		if field.IsMap() {
+			if field.MapValue().Message() == nil {
+				continue
+			}
+
+			message.Get(field).Map().Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+				causes = append(causes, collectProtobufErrors(value.Message(), location+"/"+key.String())...)
+				return true
+			})
+		} else if field.Message() != nil {
+			if field.IsList() {
+				list := message.Get(field).List()
+				for j := 0; j < list.Len(); j++ {
+					causes = append(causes, collectProtobufErrors(list.Get(j).Message(), fmt.Sprintf("%s/%d", location, j))...)
+				}
+			} else {
+				causes = append(causes, collectProtobufErrors(message.Get(field).Message(), location)...)
+			}
+		}
+	}
+
+	unknown := message.GetUnknown()
+	for len(unknown) > 0 {
+		number, wireType, tagLength := protowire.ConsumeTag(unknown)
+		if tagLength < 0 {
+			break
+		}
+
+		valueLength := protowire.ConsumeFieldValue(number, wireType, unknown[tagLength:])
+		if valueLength < 0 {
+			break
+		}
+
+		causes = append(causes, protobufError(fmt.Sprintf("%s/%d", path, number), fmt.Sprintf("unknown field number %d", number)))
+		unknown = unknown[tagLength+valueLength:]
+	}
+
+	return causes
+}
+
+// newProtobufValidationError uses the same error shape as the json schema validator so that
+// callers receive a consistent set of errors regardless of the schema format
+func newProtobufValidationError(causes ...*jsonSchema.ValidationError) *jsonSchema.ValidationError {
+	return &jsonSchema.ValidationError{
+		KeywordLocation: protobufKeywordLocation,
+		Message:         "payload does not match the protobuf message",
+		Causes:          causes,
+	}
+}
+
+func protobufError(location string, message string) *jsonSchema.ValidationError {
+	return &jsonSchema.ValidationError{
+		KeywordLocation:  protobufKeywordLocation,
+		InstanceLocation: location,
+		Message:          message,
+	}
+}
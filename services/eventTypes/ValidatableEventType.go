@@ -1,15 +1,125 @@
 package eventTypes
 
 import (
-	jsonSchema "github.com/santhosh-tekuri/jsonschema/v5"
+	"fmt"
+	"strconv"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	types "github.com/projectkeas/crds/pkg/apis/keas.io/v1alpha1"
+	"github.com/projectkeas/ingestion/services"
 )
 
+const (
+	// SchemaFormatAnnotation selects the kind of schema stored in the EventType, defaults to json
+	SchemaFormatAnnotation string = "keas.io/schema-format"
+	// ProtobufMessageAnnotation is the fully qualified name of the message within a protobuf descriptor set
+	ProtobufMessageAnnotation string = "keas.io/protobuf-message"
+	// SchemaIdAnnotation is the schema registry id used by producers writing the Confluent wire format
+	SchemaIdAnnotation string = "keas.io/schema-id"
+	// EventTypeAnnotation is the cloud event type that the schema applies to when no dataschema is supplied
+	EventTypeAnnotation string = "keas.io/event-type"
+	// EventVersionAnnotation is the version of the event type, matched against the version extension of the event
+	EventVersionAnnotation string = "keas.io/event-version"
+
+	SchemaFormatJson     string = "json"
+	SchemaFormatProtobuf string = "protobuf"
+	SchemaFormatAvro     string = "avro"
+)
+
+// EventTypeDescription identifies the EventType that an event was matched to
+type EventTypeDescription struct {
+	Name         string `json:"name"`
+	Namespace    string `json:"namespace"`
+	SchemaUri    string `json:"schemaUri"`
+	Format       string `json:"format"`
+	SchemaId     int    `json:"schemaId,omitempty"`
+	EventType    string `json:"eventType,omitempty"`
+	EventVersion string `json:"eventVersion,omitempty"`
+}
+
+type schemaValidator interface {
+	Validate(event cloudevents.Event, data interface{}) error
+}
+
 type validatableEventType struct {
-	schema    jsonSchema.Schema
-	schemaUri string
-	version   string
+	validator    schemaValidator
+	name         string
+	namespace    string
+	format       string
+	schemaUri    string
+	schemaId     int
+	eventType    string
+	eventVersion string
+	version      string
+}
+
+func (vt validatableEventType) describe() EventTypeDescription {
+	return EventTypeDescription{
+		Name:         vt.name,
+		Namespace:    vt.namespace,
+		SchemaUri:    vt.schemaUri,
+		Format:       vt.format,
+		SchemaId:     vt.schemaId,
+		EventType:    vt.eventType,
+		EventVersion: vt.eventVersion,
+	}
 }
 
-func (vt validatableEventType) Validate(data map[string]interface{}) error {
-	return vt.schema.Validate(data)
+func (vt validatableEventType) Validate(event cloudevents.Event, data interface{}) error {
+	return vt.validator.Validate(event, data)
+}
+
+// ValidateEventType checks that the schema of the EventType compiles in the same way as it would when it's loaded
+func ValidateEventType(eventType *types.EventType) error {
+	if eventType.Spec.SchemaUri == "" {
+		return fmt.Errorf("the schemaUri must be specified")
+	}
+
+	_, err := compileEventType(eventType)
+	return err
+}
+
+func compileEventType(eventType *types.EventType) (validatableEventType, error) {
+	var validator schemaValidator
+	var err error
+
+	schemaId := 0
+	if value, found := eventType.Annotations[SchemaIdAnnotation]; found {
+		schemaId, err = strconv.Atoi(value)
+		if err != nil || schemaId <= 0 {
+			return validatableEventType{}, fmt.Errorf("the %s annotation must be a positive integer", SchemaIdAnnotation)
+		}
+	}
+
+	format := eventType.Annotations[SchemaFormatAnnotation]
+	switch format {
+	case "", SchemaFormatJson:
+		validator, err = newJsonSchemaValidator(eventType.Spec.Schema)
+	case SchemaFormatProtobuf:
+		validator, err = newProtobufValidator(eventType.Spec.Schema, eventType.Annotations[ProtobufMessageAnnotation])
+	case SchemaFormatAvro:
+		validator, err = newAvroValidator(eventType.Spec.Schema, schemaId)
+	default:
+		err = fmt.Errorf("unknown schema format: %s", format)
+	}
+
+	if err != nil {
+		return validatableEventType{}, err
+	}
+
+	if format == "" {
+		format = SchemaFormatJson
+	}
+
+	return validatableEventType{
+		validator:    validator,
+		name:         eventType.Name,
+		namespace:    eventType.Namespace,
+		format:       format,
+		schemaUri:    eventType.Spec.SchemaUri,
+		schemaId:     schemaId,
+		eventType:    eventType.Annotations[EventTypeAnnotation],
+		eventVersion: eventType.Annotations[EventVersionAnnotation],
+		version:      services.Revision(eventType),
+	}, nil
 }
@@ -0,0 +1,84 @@
+package ingestionPolicies
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	types "github.com/projectkeas/crds/pkg/apis/keas.io/v1alpha1"
+	"github.com/projectkeas/sdks-service/opa"
+)
+
+// compileIngestionPolicy compiles the policy into its own OPA service so that a compiled policy is never
+// modified after it has been published in a snapshot
+func compileIngestionPolicy(key string, ingestionPolicy *types.IngestionPolicy) (*opa.OPAService, error) {
+	allow := false
+	allow = ingestionPolicy.Spec.Defaults.Allow
+
+	compiled := &opa.OPAService{}
+	err := compiled.AddOrUpdatePolicy(ingestionPolicyPackage, key, map[string]interface{}{
+		"allow":      allow,
+		"reason":     "",
+		"subject":    "",
+		"retention":  "",
+		"extensions": map[string]interface{}{},
+		"payload":    nil,
+		"applicable": true,
+	}, policyDefaults+ingestionPolicy.Spec.Policy)
+
+	return compiled, err
+}
+
+// ValidateIngestionPolicy checks that the policy compiles in the same way as it would when it's loaded,
+// that it defines an allow rule and that its annotations are valid
+func ValidateIngestionPolicy(ingestionPolicy *types.IngestionPolicy) error {
+	_, err := compileIngestionPolicy(ingestionPolicy.Namespace+"/"+ingestionPolicy.Name, ingestionPolicy)
+	if err != nil {
+		return err
+	}
+
+	problems := []string{}
+
+	module, err := ast.ParseModule(ingestionPolicyPackage+".rego", fmt.Sprintf("package %s\n\n%s", ingestionPolicyPackage, ingestionPolicy.Spec.Policy))
+	if err != nil {
+		return err
+	}
+
+	hasAllow := false
+	for _, rule := range module.Rules {
+		if rule.Head.Name.Equal(ast.Var("allow")) && !rule.Default {
+			hasAllow = true
+		}
+	}
+	if !hasAllow {
+		problems = append(problems, "the policy must define an allow rule")
+	}
+
+	annotations := ingestionPolicy.Annotations
+	if value, found := annotations[PriorityAnnotation]; found {
+		_, err := strconv.Atoi(value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("the %s annotation must be an integer", PriorityAnnotation))
+		}
+	}
+
+	if mode := annotations[ModeAnnotation]; mode != "" && mode != ModeEnforce && mode != ModeShadow {
+		problems = append(problems, fmt.Sprintf("the %s annotation must be %s or %s", ModeAnnotation, ModeEnforce, ModeShadow))
+	}
+
+	for _, pattern := range splitAnnotation(annotations[TypeSelectorAnnotation]) {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("the %s annotation contains an invalid pattern: %s", TypeSelectorAnnotation, pattern))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
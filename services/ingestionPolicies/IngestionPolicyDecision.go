@@ -1,5 +1,108 @@
 package ingestionPolicies
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 type IngestionPolicyDecision struct {
 	Allow bool
+	// Reason is the human readable reason returned by the policy that denied the event
+	Reason string
+	// Policy is the name of the policy that denied the event
+	Policy string
+	// Extensions are additional extension attributes to stamp on the event before it is published
+	Extensions map[string]interface{}
+	// Subject overrides the NATS subject that the event is published to
+	Subject string
+	// Retention is a hint to downstream consumers for how long the event should be kept
+	Retention string
+	// Payload is the payload after any transformations applied by the policies
+	Payload interface{}
+	// Transformed is true when one or more policies returned a new payload
+	Transformed bool
+	// Evaluations is the result of every policy, in evaluation order. Only populated by Explain
+	Evaluations []PolicyEvaluation
+}
+
+const (
+	// OutcomeAllow is a policy that allowed the event
+	OutcomeAllow string = "allow"
+	// OutcomeDeny is a policy that denied the event
+	OutcomeDeny string = "deny"
+	// OutcomeNotApplicable is a policy that declared itself not applicable to the event
+	OutcomeNotApplicable string = "not-applicable"
+	// OutcomeNotSelected is a policy whose selectors don't match the event, it isn't evaluated
+	OutcomeNotSelected string = "not-selected"
+	// OutcomeError is a shadow policy that failed to evaluate, enforced policies that fail reject the event
+	OutcomeError string = "error"
+)
+
+// PolicyEvaluation is the result of a single policy when explaining a decision
+type PolicyEvaluation struct {
+	Policy    string `json:"policy"`
+	Namespace string `json:"namespace"`
+	Priority  int    `json:"priority"`
+	Mode      string `json:"mode"`
+	Outcome   string `json:"outcome"`
+	Reason    string `json:"reason,omitempty"`
+	// Enforced is true when the outcome contributed to the decision, ie: it isn't a shadow policy
+	// and the decision hadn't already been made by an earlier policy
+	Enforced bool `json:"enforced"`
+}
+
+func newPolicyEvaluation(policy policyEntry) PolicyEvaluation {
+	mode := ModeEnforce
+	if policy.shadow {
+		mode = ModeShadow
+	}
+
+	return PolicyEvaluation{
+		Policy:    policy.name,
+		Namespace: policy.namespace,
+		Priority:  policy.priority,
+		Mode:      mode,
+	}
+}
+
+func stringBinding(bindings map[string]interface{}, key string) string {
+	value, isString := bindings[key].(string)
+	if isString {
+		return value
+	}
+	return ""
+}
+
+// extensionBindings converts the extensions returned by a policy into values that are supported
+// by the cloudevents type system
+func extensionBindings(bindings map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+
+	extensions, isMap := bindings["extensions"].(map[string]interface{})
+	if !isMap {
+		return result
+	}
+
+	for name, value := range extensions {
+		switch v := value.(type) {
+		case string, bool:
+			result[name] = v
+		case json.Number:
+			i, err := v.Int64()
+			if err == nil && i >= -2147483648 && i <= 2147483647 {
+				result[name] = int32(i)
+			} else {
+				result[name] = v.String()
+			}
+		default:
+			encoded, err := json.Marshal(v)
+			if err == nil {
+				result[name] = string(encoded)
+			} else {
+				result[name] = fmt.Sprint(v)
+			}
+		}
+	}
+
+	return result
 }
@@ -0,0 +1,128 @@
+package ingestionPolicies
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	types "github.com/projectkeas/crds/pkg/apis/keas.io/v1alpha1"
+	"github.com/projectkeas/ingestion/services"
+	log "github.com/projectkeas/sdks-service/logger"
+	"github.com/projectkeas/sdks-service/opa"
+	"go.uber.org/zap"
+)
+
+const (
+	// PriorityAnnotation orders the evaluation of ingestion policies, higher priorities are evaluated first
+	PriorityAnnotation string = "keas.io/priority"
+	// TypeSelectorAnnotation is a comma separated list of event type globs that the policy applies to
+	TypeSelectorAnnotation string = "keas.io/selector-types"
+	// SourceSelectorAnnotation is a comma separated list of event source prefixes that the policy applies to
+	SourceSelectorAnnotation string = "keas.io/selector-sources"
+	// DataSchemaSelectorAnnotation is a comma separated list of dataschemas that the policy applies to
+	DataSchemaSelectorAnnotation string = "keas.io/selector-dataschemas"
+	// ModeAnnotation controls whether the decision of the policy is enforced or only logged
+	ModeAnnotation string = "keas.io/mode"
+
+	// ModeEnforce applies the decision of the policy to the event
+	ModeEnforce string = "enforce"
+	// ModeShadow evaluates the policy and records what it would have done without applying the decision
+	ModeShadow string = "shadow"
+
+	// CombiningDenyOverrides denies the event when any applicable policy denies it
+	CombiningDenyOverrides string = "deny-overrides"
+	// CombiningAllowOverrides allows the event when any applicable policy allows it
+	CombiningAllowOverrides string = "allow-overrides"
+	// CombiningFirstApplicable uses the decision of the first applicable policy
+	CombiningFirstApplicable string = "first-applicable"
+)
+
+type policyEntry struct {
+	opa         *opa.OPAService
+	key         string
+	name        string
+	namespace   string
+	priority    int
+	version     string
+	types       []string
+	sources     []string
+	dataSchemas []string
+	shadow      bool
+	rank        int
+}
+
+func newPolicyEntry(compiled *opa.OPAService, opaNamespace string, key string, ingestionPolicy *types.IngestionPolicy) policyEntry {
+	priority := 0
+	value, found := ingestionPolicy.Annotations[PriorityAnnotation]
+	if found {
+		parsed, err := strconv.Atoi(value)
+		if err == nil {
+			priority = parsed
+		} else {
+			log.Logger.Warn("invalid ingestion policy priority, using 0", zap.Any("ingestionPolicy", map[string]string{
+				"name":      ingestionPolicy.Name,
+				"namespace": ingestionPolicy.Namespace,
+				"priority":  value,
+			}))
+		}
+	}
+
+	mode := ingestionPolicy.Annotations[ModeAnnotation]
+	if mode != "" && mode != ModeEnforce && mode != ModeShadow {
+		log.Logger.Warn("invalid ingestion policy mode, using "+ModeEnforce, zap.Any("ingestionPolicy", map[string]string{
+			"name":      ingestionPolicy.Name,
+			"namespace": ingestionPolicy.Namespace,
+			"mode":      mode,
+		}))
+	}
+
+	return policyEntry{
+		opa:         compiled,
+		key:         fmt.Sprintf("%s|%s", opaNamespace, key),
+		name:        ingestionPolicy.Name,
+		namespace:   ingestionPolicy.Namespace,
+		priority:    priority,
+		version:     services.Revision(ingestionPolicy),
+		types:       splitAnnotation(ingestionPolicy.Annotations[TypeSelectorAnnotation]),
+		sources:     splitAnnotation(ingestionPolicy.Annotations[SourceSelectorAnnotation]),
+		dataSchemas: splitAnnotation(ingestionPolicy.Annotations[DataSchemaSelectorAnnotation]),
+		shadow:      mode == ModeShadow,
+	}
+}
+
+func splitAnnotation(value string) []string {
+	result := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// orderPolicies sorts the policies by descending priority, policies with the same priority are
+// ordered by namespace & name so that the evaluation order is always deterministic
+func orderPolicies(policies map[string]policyEntry) []policyEntry {
+	result := make([]policyEntry, 0, len(policies))
+	for _, policy := range policies {
+		result = append(result, policy)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].priority != result[j].priority {
+			return result[i].priority > result[j].priority
+		}
+		if result[i].namespace != result[j].namespace {
+			return result[i].namespace < result[j].namespace
+		}
+		return result[i].name < result[j].name
+	})
+
+	for i := range result {
+		result[i].rank = i
+	}
+
+	return result
+}
@@ -0,0 +1,133 @@
+package ingestionPolicies
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// policyIndex narrows down the policies that need to be evaluated for an event using the selectors
+// of each policy so that we don't evaluate every policy for every event
+type policyIndex struct {
+	all      []policyEntry
+	byType   map[string][]policyEntry
+	wildcard []policyEntry
+	unscoped []policyEntry
+}
+
+func newPolicyIndex(ordered []policyEntry) policyIndex {
+	index := policyIndex{
+		all:    ordered,
+		byType: map[string][]policyEntry{},
+	}
+
+	for _, policy := range ordered {
+		if len(policy.types) == 0 {
+			index.unscoped = append(index.unscoped, policy)
+			continue
+		}
+
+		hasWildcard := false
+		for _, eventType := range policy.types {
+			if strings.ContainsAny(eventType, "*?[") {
+				hasWildcard = true
+			} else {
+				index.byType[eventType] = append(index.byType[eventType], policy)
+			}
+		}
+
+		if hasWildcard {
+			index.wildcard = append(index.wildcard, policy)
+		}
+	}
+
+	return index
+}
+
+// match returns the policies that apply to the event in evaluation order
+func (index policyIndex) match(event cloudevents.Event) []policyEntry {
+	candidates := map[int]policyEntry{}
+
+	for _, policy := range index.byType[event.Type()] {
+		candidates[policy.rank] = policy
+	}
+
+	for _, policy := range index.wildcard {
+		if matchesType(policy, event.Type()) {
+			candidates[policy.rank] = policy
+		}
+	}
+
+	for _, policy := range index.unscoped {
+		candidates[policy.rank] = policy
+	}
+
+	result := make([]policyEntry, 0, len(candidates))
+	for _, policy := range candidates {
+		if matchesSource(policy, event.Source()) && matchesDataSchema(policy, event.DataSchema()) {
+			result = append(result, policy)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].rank < result[j].rank
+	})
+
+	return result
+}
+
+// explain orders the evaluations of the matched policies, the policies that weren't selected are only added
+// when all is set
+func (index policyIndex) explain(evaluations map[int]PolicyEvaluation, all bool) []PolicyEvaluation {
+	result := make([]PolicyEvaluation, 0, len(index.all))
+	for _, policy := range index.all {
+		evaluation, found := evaluations[policy.rank]
+		if !found {
+			if !all {
+				continue
+			}
+			evaluation = newPolicyEvaluation(policy)
+			evaluation.Outcome = OutcomeNotSelected
+		}
+		result = append(result, evaluation)
+	}
+	return result
+}
+
+func matchesType(policy policyEntry, eventType string) bool {
+	for _, pattern := range policy.types {
+		matched, err := path.Match(pattern, eventType)
+		if err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesSource(policy policyEntry, source string) bool {
+	if len(policy.sources) == 0 {
+		return true
+	}
+
+	for _, prefix := range policy.sources {
+		if strings.HasPrefix(source, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesDataSchema(policy policyEntry, dataSchema string) bool {
+	if len(policy.dataSchemas) == 0 {
+		return true
+	}
+
+	for _, schema := range policy.dataSchemas {
+		if schema == dataSchema {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package ingestionPolicies
+
+import (
+	"expvar"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	log "github.com/projectkeas/sdks-service/logger"
+	"go.uber.org/zap"
+)
+
+var (
+	// shadowEvaluations counts the events evaluated by each shadow policy
+	shadowEvaluations = expvar.NewMap("ingestion_policy_shadow_evaluations")
+	// shadowDenials counts the events that each shadow policy would have denied
+	shadowDenials = expvar.NewMap("ingestion_policy_shadow_denials")
+	// shadowErrors counts the events that each shadow policy failed to evaluate
+	shadowErrors = expvar.NewMap("ingestion_policy_shadow_errors")
+)
+
+// recordShadowDecision logs & counts the decision of a policy in shadow mode so that it can be
+// compared against production traffic before it is enforced
+func recordShadowDecision(policy policyEntry, event cloudevents.Event, allow bool, reason string) {
+	key := policy.namespace + "/" + policy.name
+	shadowEvaluations.Add(key, 1)
+
+	if allow {
+		return
+	}
+
+	shadowDenials.Add(key, 1)
+	log.Logger.Info("shadow ingestion policy would have denied the event",
+		zap.Any("ingestionPolicy", map[string]string{
+			"name":      policy.name,
+			"namespace": policy.namespace,
+		}),
+		zap.String("reason", reason),
+		zap.Any("event", map[string]string{
+			"id":     event.ID(),
+			"type":   event.Type(),
+			"source": event.Source(),
+		}),
+	)
+}
+
+// recordShadowError logs & counts a shadow policy that failed to evaluate, the event continues as if the
+// policy didn't exist
+func recordShadowError(policy policyEntry, event cloudevents.Event, err error) {
+	key := policy.namespace + "/" + policy.name
+	shadowEvaluations.Add(key, 1)
+	shadowErrors.Add(key, 1)
+
+	log.Logger.Warn("shadow ingestion policy failed to evaluate the event",
+		zap.Any("ingestionPolicy", map[string]string{
+			"name":      policy.name,
+			"namespace": policy.namespace,
+		}),
+		zap.Error(err),
+		zap.Any("event", map[string]string{
+			"id":     event.ID(),
+			"type":   event.Type(),
+			"source": event.Source(),
+		}),
+	)
+}
@@ -1,14 +1,18 @@
 package ingestionPolicies
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	spec "github.com/cloudevents/sdk-go/v2/binding/spec"
 	types "github.com/projectkeas/crds/pkg/apis/keas.io/v1alpha1"
 	"github.com/projectkeas/ingestion/services"
+	"github.com/projectkeas/ingestion/services/authentication"
+	"github.com/projectkeas/ingestion/services/resourceStatus"
+	"github.com/projectkeas/sdks-service/configuration"
 	log "github.com/projectkeas/sdks-service/logger"
-	"github.com/projectkeas/sdks-service/opa"
 	"go.uber.org/zap"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
@@ -16,28 +20,93 @@ import (
 
 const (
 	SERVICE_NAME string = "IngestionPolicies"
+
+	// ingestionPolicyPackage is the rego package that every ingestion policy is compiled into. Policies are
+	// registered with the OPA service using their namespace/name key so that they never collide
+	ingestionPolicyPackage string = "keas.ingestion"
 )
 
 var (
 	specs = spec.New().Version("1.0")
 )
 
+// policyDefaults are prepended to each policy for the outputs that the OPA service can't default, ie: objects
+const policyDefaults = "default extensions = {}\ndefault payload = null\n\n"
+
 type IngestionPolicyService interface {
-	GetDecision(event cloudevents.Event, data map[string]interface{}) (IngestionPolicyDecision, error)
+	// GetDecision evaluates the policies that apply to the event, the principal is nil when the event
+	// wasn't sent by an authenticated request, eg: when a dead letter without a principal is replayed
+	GetDecision(event cloudevents.Event, data interface{}, principal *authentication.Principal) (IngestionPolicyDecision, error)
+	// Explain makes the same decision as GetDecision but evaluates every policy and records the result
+	// of each one that applies to the event in the Evaluations of the decision. The policies whose selectors
+	// don't match the event are only included for admin principals as they belong to other producers. Shadow
+	// policies are not logged or counted.
+	Explain(event cloudevents.Event, data interface{}, principal *authentication.Principal) (IngestionPolicyDecision, error)
 }
 
+// ingestionExecutionService serves decisions from an immutable snapshot of the policies which is swapped
+// atomically when a policy changes, so requests never take a lock or observe a partially applied update
 type ingestionExecutionService struct {
-	opa      *opa.OPAService
-	versions map[string]string
+	snapshot  atomic.Value // policySnapshot
+	combining atomic.Value // string
+	mutex     sync.Mutex   // serialises updates to the snapshot
+	status    resourceStatus.StatusReporter
+}
+
+// policySnapshot must not be modified once it has been stored, updates create a new snapshot
+type policySnapshot struct {
+	policies map[string]policyEntry
+	index    policyIndex
+}
+
+func newPolicySnapshot(policies map[string]policyEntry) policySnapshot {
+	return policySnapshot{
+		policies: policies,
+		index:    newPolicyIndex(orderPolicies(policies)),
+	}
+}
+
+func (ies *ingestionExecutionService) load() policySnapshot {
+	return ies.snapshot.Load().(policySnapshot)
+}
+
+// update applies a change to a copy of the current policies and swaps in the new snapshot
+func (ies *ingestionExecutionService) update(change func(policies map[string]policyEntry) bool) bool {
+	ies.mutex.Lock()
+	defer ies.mutex.Unlock()
+
+	current := ies.load()
+	policies := make(map[string]policyEntry, len(current.policies)+1)
+	for key, policy := range current.policies {
+		policies[key] = policy
+	}
+
+	if !change(policies) {
+		return false
+	}
+
+	ies.snapshot.Store(newPolicySnapshot(policies))
+	return true
+}
+
+func (ies *ingestionExecutionService) GetDecision(event cloudevents.Event, data interface{}, principal *authentication.Principal) (IngestionPolicyDecision, error) {
+	return ies.decide(event, data, principal, false)
 }
 
-func (ies *ingestionExecutionService) GetDecision(event cloudevents.Event, data map[string]interface{}) (IngestionPolicyDecision, error) {
+func (ies *ingestionExecutionService) Explain(event cloudevents.Event, data interface{}, principal *authentication.Principal) (IngestionPolicyDecision, error) {
+	return ies.decide(event, data, principal, true)
+}
+
+func (ies *ingestionExecutionService) decide(event cloudevents.Event, data interface{}, principal *authentication.Principal, explain bool) (IngestionPolicyDecision, error) {
 	result := &IngestionPolicyDecision{
-		Allow: true,
+		Allow:      true,
+		Extensions: map[string]interface{}{},
+		Payload:    data,
 	}
 
-	keys := ies.opa.GetPolicyKeys()
-	if len(keys) == 0 {
+	index := ies.load().index
+	policies := index.match(event)
+	if len(policies) == 0 && !explain {
 		return *result, nil
 	}
 
@@ -51,34 +120,149 @@ func (ies *ingestionExecutionService) GetDecision(event cloudevents.Event, data
 			specs.AttributeFromKind(spec.Subject).Name():         event.Subject(),
 			specs.AttributeFromKind(spec.Time).Name():            event.Time(),
 			specs.AttributeFromKind(spec.Type).Name():            event.Type(),
+			"extensions": event.Extensions(),
 		},
-		"payload": data,
+		"payload":   data,
+		"principal": nil,
 	}
 
-	for _, key := range keys {
-		decision, err := ies.opa.EvaluatePolicy(key, subject)
+	if principal != nil {
+		subject["principal"] = principal.Input()
+	}
+
+	combining := ies.combining.Load().(string)
+	var denied *IngestionPolicyDecision
+	var final *IngestionPolicyDecision
+	allowed := false
+	evaluations := map[int]PolicyEvaluation{}
+
+	for _, policy := range policies {
+		// Once the verdict has been reached the remaining policies are only evaluated to explain them,
+		// shadow policies are still evaluated so that their metrics reflect all of the traffic
+		if final != nil && !explain && !policy.shadow {
+			continue
+		}
+
+		decision, err := policy.opa.EvaluatePolicy(policy.key, subject)
+		evaluation := newPolicyEvaluation(policy)
 
 		if err != nil {
+			// A shadow policy must never affect ingestion, its failures are only recorded
+			if policy.shadow {
+				if !explain {
+					recordShadowError(policy, event, err)
+				}
+				evaluation.Outcome = OutcomeError
+				evaluation.Reason = err.Error()
+				evaluations[policy.rank] = evaluation
+				continue
+			}
 			return IngestionPolicyDecision{}, err
 		}
 
-		allow := decision[0].Bindings["allow"].(bool)
+		bindings := decision[0].Bindings
+
+		applicable, isBool := bindings["applicable"].(bool)
+		if isBool && !applicable {
+			evaluation.Outcome = OutcomeNotApplicable
+			evaluations[policy.rank] = evaluation
+			continue
+		}
+
+		allow, _ := bindings["allow"].(bool)
+		reason := stringBinding(bindings, "reason")
+		evaluation.Outcome = OutcomeAllow
+		if !allow {
+			evaluation.Outcome = OutcomeDeny
+			evaluation.Reason = reason
+		}
+		evaluation.Enforced = final == nil && !policy.shadow
+		evaluations[policy.rank] = evaluation
+
+		if policy.shadow {
+			if !explain {
+				recordShadowDecision(policy, event, allow, reason)
+			}
+			continue
+		}
+
+		if final != nil {
+			continue
+		}
+
 		if !allow {
-			result.Allow = false
-			return *result, nil
+			if denied == nil {
+				denied = &IngestionPolicyDecision{
+					Allow:  false,
+					Reason: reason,
+					Policy: policy.name,
+				}
+			}
+
+			if combining != CombiningAllowOverrides {
+				final = denied
+			}
+			continue
+		}
+
+		allowed = true
+		for name, value := range extensionBindings(bindings) {
+			result.Extensions[name] = value
+		}
+		if result.Subject == "" {
+			result.Subject = stringBinding(bindings, "subject")
+		}
+		if result.Retention == "" {
+			result.Retention = stringBinding(bindings, "retention")
+		}
+
+		// Subsequent policies are evaluated against the transformed payload
+		payload, found := bindings["payload"]
+		if found && payload != nil {
+			result.Payload = payload
+			result.Transformed = true
+			subject["payload"] = payload
+		}
+
+		if combining == CombiningFirstApplicable {
+			result.Policy = policy.name
+			final = result
+		}
+	}
+
+	if final == nil {
+		if denied != nil && !allowed {
+			final = denied
+		} else {
+			final = result
 		}
 	}
 
-	return *result, nil
+	if explain {
+		final.Evaluations = index.explain(evaluations, principal != nil && principal.Admin)
+	}
+
+	return *final, nil
 }
 
-func New() IngestionPolicyService {
+func New(config *configuration.ConfigurationRoot, status resourceStatus.StatusReporter) IngestionPolicyService {
 
-	opa := &opa.OPAService{}
 	svc := &ingestionExecutionService{
-		opa:      opa,
-		versions: map[string]string{},
+		status: status,
 	}
+	svc.snapshot.Store(newPolicySnapshot(map[string]policyEntry{}))
+	svc.combining.Store(CombiningDenyOverrides)
+
+	config.RegisterChangeNotificationHandler(func(c configuration.ConfigurationRoot) {
+		combining := c.GetStringValueOrDefault("ingestion.policies.combining", CombiningDenyOverrides)
+		switch combining {
+		case CombiningDenyOverrides, CombiningAllowOverrides, CombiningFirstApplicable:
+			svc.combining.Store(combining)
+		default:
+			log.Logger.Error("unknown policy combining algorithm, using "+CombiningDenyOverrides, zap.String("combining", combining))
+			svc.combining.Store(CombiningDenyOverrides)
+		}
+	})
 
 	informer := services.GetInformer()
 	ingestionPoliciesFactory := informer.Keas().V1alpha1().IngestionPolicies()
@@ -127,33 +311,69 @@ func onUpdatedIngestionPolicy(svc *ingestionExecutionService) func(oldPolicyInte
 }
 
 func addOrUpdateIngestionPolicy(svc *ingestionExecutionService, ingestionPolicy *types.IngestionPolicy) bool {
-	version, found := svc.versions[ingestionPolicy.Name]
-	if found && version == ingestionPolicy.ResourceVersion {
+	key, err := cache.MetaNamespaceKeyFunc(ingestionPolicy)
+	if err != nil {
+		log.Logger.Error("could not determine the key of the ingestion policy", zap.Error(err))
 		return false
 	}
 
-	allow := false
-	allow = ingestionPolicy.Spec.Defaults.Allow
+	entry, found := svc.load().policies[key]
+	if found && entry.version == services.Revision(ingestionPolicy) {
+		return false
+	}
 
-	svc.opa.AddOrUpdatePolicy("keas.ingestion", ingestionPolicy.Name, map[string]interface{}{
-		"allow": allow,
-	}, ingestionPolicy.Spec.Policy)
-	svc.versions[ingestionPolicy.Name] = ingestionPolicy.ResourceVersion
-	return true
+	compiled, err := compileIngestionPolicy(key, ingestionPolicy)
+	if err != nil {
+		log.Logger.Error("Cannot compile ingestion policy. Not adding policy to collection", zap.Any("ingestionPolicy", map[string]string{
+			"name":      ingestionPolicy.Name,
+			"namespace": ingestionPolicy.Namespace,
+		}), zap.Error(err))
+		svc.status.CompileFailed(resourceStatus.KindIngestionPolicy, ingestionPolicy, err)
+		return false
+	}
+
+	svc.status.Compiled(resourceStatus.KindIngestionPolicy, ingestionPolicy)
+	return svc.update(func(policies map[string]policyEntry) bool {
+		entry, found := policies[key]
+		if found && entry.version == services.Revision(ingestionPolicy) {
+			return false
+		}
+
+		policies[key] = newPolicyEntry(compiled, ingestionPolicyPackage, key, ingestionPolicy)
+		return true
+	})
 }
 
 func onDeletedIngestionPolicy(svc *ingestionExecutionService) func(policyInterface interface{}) {
 	return func(policyInterface interface{}) {
-		ingestionPolicy, successfulCast := policyInterface.(*types.IngestionPolicy)
-		if successfulCast {
-			svc.opa.RemovePolicy(ingestionPolicy.Namespace, ingestionPolicy.Name)
-			delete(svc.versions, ingestionPolicy.Name)
+		// The informer may hand us a tombstone (cache.DeletedFinalStateUnknown) when the delete was
+		// missed whilst disconnected, the key is still available from the tombstone
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(policyInterface)
+		if err != nil {
+			log.Logger.Error("could not determine the key of the deleted ingestion policy", zap.Error(err))
+			return
+		}
+
+		namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+		svc.status.Forget(resourceStatus.KindIngestionPolicy, namespace, name)
+
+		if removeIngestionPolicy(svc, key) {
 			log.Logger.Info("Deleted ingestion policy. the policy is no longer in effect", zap.Any("ingestionPolicy", map[string]string{
-				"name":      ingestionPolicy.Name,
-				"namespace": ingestionPolicy.Namespace,
+				"name":      name,
+				"namespace": namespace,
 			}))
-		} else {
-			log.Logger.Error("could not cast ingestion policy")
 		}
 	}
 }
+
+func removeIngestionPolicy(svc *ingestionExecutionService, key string) bool {
+	return svc.update(func(policies map[string]policyEntry) bool {
+		_, found := policies[key]
+		if !found {
+			return false
+		}
+
+		delete(policies, key)
+		return true
+	})
+}
@@ -0,0 +1,347 @@
+package resourceStatus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	keasTypes "github.com/projectkeas/crds/pkg/apis/keas.io/v1alpha1"
+	keasClientSet "github.com/projectkeas/crds/pkg/client/clientset/versioned"
+	"github.com/projectkeas/sdks-service/configuration"
+	log "github.com/projectkeas/sdks-service/logger"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	clientScheme "k8s.io/client-go/kubernetes/scheme"
+	typedCoreV1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// ConditionReady is true when the resource has been compiled and is in effect
+	ConditionReady string = "Ready"
+	// ConditionCompileError is true when the latest generation of the resource failed to compile
+	ConditionCompileError string = "CompileError"
+
+	reasonCompiled     string = "Compiled"
+	reasonCompileError string = "CompileError"
+
+	KindEventType       string = "EventType"
+	KindIngestionPolicy string = "IngestionPolicy"
+
+	component string = "ingestion"
+)
+
+// Resource is an EventType or IngestionPolicy that status can be reported for
+type Resource interface {
+	metav1.Object
+	runtime.Object
+}
+
+// StatusReporter writes the outcome of compiling an EventType or IngestionPolicy back to the cluster as
+// conditions on the status subresource and as Kubernetes Events so that authors can see it with kubectl
+type StatusReporter interface {
+	Compiled(kind string, resource Resource)
+	CompileFailed(kind string, resource Resource, err error)
+	Forget(kind string, namespace string, name string)
+}
+
+type resourceStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration"`
+	Conditions         []metav1.Condition `json:"conditions"`
+}
+
+const (
+	// maxRetries is the number of times a status update is retried before it is dropped
+	maxRetries = 5
+)
+
+// pendingReport is a status update waiting in the queue
+type pendingReport struct {
+	kind       string
+	resource   Resource
+	conditions []metav1.Condition
+}
+
+type statusReporter struct {
+	client   keasClientSet.Interface
+	recorder record.EventRecorder
+	enabled  bool
+	// reported holds the last status reported for each resource so that the same status isn't written
+	// repeatedly, eg: on every resync or when our own status update is observed by the informer
+	reported map[string]string
+	// pending holds the latest status update waiting in the queue for each resource, the status is written by a
+	// worker so that the informer callbacks never wait for the API server
+	pending map[string]pendingReport
+	// written holds the conditions last written for each resource so that LastTransitionTime is kept until the
+	// status of the condition changes
+	written map[string][]metav1.Condition
+	// unsupported records the kinds whose CRD doesn't have the status subresource enabled
+	unsupported map[string]bool
+	queue       workqueue.RateLimitingInterface
+	lock        sync.Mutex
+}
+
+func New(config *configuration.ConfigurationRoot) StatusReporter {
+	reporter := &statusReporter{
+		reported:    map[string]string{},
+		pending:     map[string]pendingReport{},
+		written:     map[string][]metav1.Condition{},
+		unsupported: map[string]bool{},
+		queue:       workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "ingestion-status"),
+	}
+
+	config.RegisterChangeNotificationHandler(func(c configuration.ConfigurationRoot) {
+		reporter.lock.Lock()
+		defer reporter.lock.Unlock()
+		reporter.enabled = c.GetBooleanValueOrDefault("ingestion.status.enabled", true)
+	})
+
+	kubeConfig, _, err := configuration.GetKubernetesConfig()
+	if err != nil {
+		panic(err)
+	}
+
+	reporter.client, err = keasClientSet.NewForConfig(kubeConfig)
+	if err != nil {
+		panic(err)
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		panic(err)
+	}
+
+	scheme := runtime.NewScheme()
+	clientScheme.AddToScheme(scheme)
+	keasTypes.AddToScheme(scheme)
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedCoreV1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	reporter.recorder = broadcaster.NewRecorder(scheme, corev1.EventSource{Component: component})
+
+	go reporter.run()
+
+	return reporter
+}
+
+func (reporter *statusReporter) Compiled(kind string, resource Resource) {
+	reporter.report(kind, resource, []metav1.Condition{
+		{
+			Type:    ConditionReady,
+			Status:  metav1.ConditionTrue,
+			Reason:  reasonCompiled,
+			Message: fmt.Sprintf("The %s is in effect", kind),
+		},
+		{
+			Type:   ConditionCompileError,
+			Status: metav1.ConditionFalse,
+			Reason: reasonCompiled,
+		},
+	}, corev1.EventTypeNormal)
+}
+
+func (reporter *statusReporter) CompileFailed(kind string, resource Resource, err error) {
+	reporter.report(kind, resource, []metav1.Condition{
+		{
+			Type:    ConditionReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  reasonCompileError,
+			Message: fmt.Sprintf("The latest generation of the %s could not be compiled, the previous generation remains in effect if there was one", kind),
+		},
+		{
+			Type:    ConditionCompileError,
+			Status:  metav1.ConditionTrue,
+			Reason:  reasonCompileError,
+			Message: err.Error(),
+		},
+	}, corev1.EventTypeWarning)
+}
+
+func (reporter *statusReporter) Forget(kind string, namespace string, name string) {
+	key := formatResourceKey(kind, namespace, name)
+
+	reporter.lock.Lock()
+	defer reporter.lock.Unlock()
+	delete(reporter.reported, key)
+	delete(reporter.pending, key)
+	delete(reporter.written, key)
+}
+
+// report records the Kubernetes Event and queues the status update, the recorder sends events in the background
+// so neither blocks the informer
+func (reporter *statusReporter) report(kind string, resource Resource, conditions []metav1.Condition, eventType string) {
+	key := formatResourceKey(kind, resource.GetNamespace(), resource.GetName())
+	fingerprint := fmt.Sprintf("%d|%s|%s", resource.GetGeneration(), conditions[0].Status, conditions[1].Message)
+
+	reporter.lock.Lock()
+	if !reporter.enabled || reporter.reported[key] == fingerprint {
+		reporter.lock.Unlock()
+		return
+	}
+	reporter.reported[key] = fingerprint
+	unsupported := reporter.unsupported[kind]
+	if !unsupported {
+		reporter.pending[key] = pendingReport{
+			kind:       kind,
+			resource:   resource.DeepCopyObject().(Resource),
+			conditions: conditions,
+		}
+	}
+	reporter.lock.Unlock()
+
+	message := conditions[0].Message
+	if eventType == corev1.EventTypeWarning {
+		message = conditions[1].Message
+	}
+	reporter.recorder.Event(resource, eventType, conditions[0].Reason, message)
+
+	if !unsupported {
+		reporter.queue.Add(key)
+	}
+}
+
+func (reporter *statusReporter) run() {
+	for reporter.processNext() {
+	}
+}
+
+// processNext writes the latest pending status of the next resource in the queue, failures are retried
+// with a backoff
+func (reporter *statusReporter) processNext() bool {
+	item, shutdown := reporter.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer reporter.queue.Done(item)
+
+	key := item.(string)
+	reporter.lock.Lock()
+	pending, found := reporter.pending[key]
+	delete(reporter.pending, key)
+	reporter.lock.Unlock()
+
+	if !found {
+		reporter.queue.Forget(item)
+		return true
+	}
+
+	err := reporter.write(key, pending)
+	if err != nil && reporter.queue.NumRequeues(item) < maxRetries {
+		// Retry unless a newer status has been reported in the meantime
+		reporter.lock.Lock()
+		if _, newer := reporter.pending[key]; !newer {
+			reporter.pending[key] = pending
+		}
+		reporter.lock.Unlock()
+		reporter.queue.AddRateLimited(item)
+		return true
+	}
+
+	if err != nil {
+		log.Logger.Error("unable to update status, giving up", zap.String("kind", pending.kind), zap.Any("resource", map[string]string{
+			"name":      pending.resource.GetName(),
+			"namespace": pending.resource.GetNamespace(),
+		}), zap.Error(err))
+	}
+	reporter.queue.Forget(item)
+	return true
+}
+
+// write patches the status subresource, the error is returned when the update should be retried
+func (reporter *statusReporter) write(key string, pending pendingReport) error {
+	kind, resource := pending.kind, pending.resource
+
+	reporter.lock.Lock()
+	if reporter.unsupported[kind] {
+		reporter.lock.Unlock()
+		return nil
+	}
+	conditions := append([]metav1.Condition{}, reporter.written[key]...)
+	reporter.lock.Unlock()
+
+	// SetStatusCondition only moves LastTransitionTime when the status of the condition changes
+	for _, condition := range pending.conditions {
+		condition.ObservedGeneration = resource.GetGeneration()
+		meta.SetStatusCondition(&conditions, condition)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": resourceStatus{
+			ObservedGeneration: resource.GetGeneration(),
+			Conditions:         conditions,
+		},
+	})
+	if err != nil {
+		log.Logger.Error("unable to create status patch", zap.Error(err))
+		return nil
+	}
+
+	err = reporter.patchStatus(kind, resource, patch)
+	if isSubresourceMissing(err) {
+		// The CRD doesn't have the status subresource enabled, there's no point trying again
+		reporter.lock.Lock()
+		reporter.unsupported[kind] = true
+		reporter.lock.Unlock()
+		log.Logger.Warn("the status subresource is not enabled for "+kind+", status will only be reported with events", zap.Error(err))
+		return nil
+	} else if apierrors.IsNotFound(err) {
+		// The resource has been deleted since the status was reported
+		return nil
+	} else if err != nil {
+		log.Logger.Warn("unable to update status", zap.String("kind", kind), zap.Any("resource", map[string]string{
+			"name":      resource.GetName(),
+			"namespace": resource.GetNamespace(),
+		}), zap.Error(err))
+		return err
+	}
+
+	reporter.lock.Lock()
+	reporter.written[key] = conditions
+	reporter.lock.Unlock()
+	return nil
+}
+
+func (reporter *statusReporter) patchStatus(kind string, resource Resource, patch []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var err error
+	switch kind {
+	case KindEventType:
+		_, err = reporter.client.KeasV1alpha1().EventTypes(resource.GetNamespace()).Patch(ctx, resource.GetName(), k8sTypes.MergePatchType, patch, metav1.PatchOptions{}, "status")
+	case KindIngestionPolicy:
+		_, err = reporter.client.KeasV1alpha1().IngestionPolicies(resource.GetNamespace()).Patch(ctx, resource.GetName(), k8sTypes.MergePatchType, patch, metav1.PatchOptions{}, "status")
+	default:
+		err = fmt.Errorf("unknown kind: %s", kind)
+	}
+	return err
+}
+
+// isSubresourceMissing distinguishes a CRD without the status subresource from a resource that has been
+// deleted, both are reported as not found but only the latter identifies the resource
+func isSubresourceMissing(err error) bool {
+	if !apierrors.IsNotFound(err) {
+		return false
+	}
+
+	status, isStatus := err.(apierrors.APIStatus)
+	if !isStatus {
+		return false
+	}
+
+	details := status.Status().Details
+	return details == nil || details.Name == ""
+}
+
+func formatResourceKey(kind string, namespace string, name string) string {
+	return fmt.Sprintf("%s|%s/%s", kind, namespace, name)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	log "github.com/projectkeas/sdks-service/logger"
+	"github.com/projectkeas/sdks-service/server"
+
+	"github.com/projectkeas/ingestion/handlers/admissionHandler"
+)
+
+// runWebhook runs the validating admission webhook for the keas CRDs instead of the ingestion API. The API
+// server only calls webhooks over TLS so /validate is only served on webhook.port using the certificate in
+// webhook.tls.certFile & webhook.tls.keyFile. The health checks remain on server.port
+func runWebhook() {
+	app := server.New("ingestion-webhook")
+
+	app.WithEnvironmentVariableConfiguration("KEAS_")
+	app.WithConfigMap("ingestion-cm")
+
+	app.ConfigureHandlers(func(f *fiber.App, server *server.Server) {
+		config := server.GetConfiguration()
+		certFile := config.GetStringValueOrDefault("webhook.tls.certFile", "")
+		keyFile := config.GetStringValueOrDefault("webhook.tls.keyFile", "")
+		if certFile == "" || keyFile == "" {
+			log.Logger.Error("webhook.tls.certFile and webhook.tls.keyFile must be set, the webhook is not available")
+			return
+		}
+
+		// The webhook has its own app so that /validate isn't also served without TLS on server.port
+		webhook := fiber.New()
+		// A panic whilst compiling a crafted resource must not take down the admission server
+		webhook.Use(recover.New())
+		webhook.Post("/validate", admissionHandler.New(server))
+
+		port := config.GetStringValueOrDefault("webhook.port", "8443")
+		go func() {
+			err := webhook.ListenTLS(":"+port, certFile, keyFile)
+			if err != nil {
+				log.Logger.Panic(err.Error())
+			}
+		}()
+	})
+
+	app.Build().Run()
+}